@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// close reasons reported in access log
+const (
+	closeReasonClosed   = "closed"
+	closeReasonEOF      = "eof"
+	closeReasonTimeout  = "timeout"
+	closeReasonError    = "error"
+	closeReasonIdle     = "idle"
+	closeReasonShutdown = "shutdown"
+	closeReasonAdmin    = "admin"
+	closeReasonRejected = "rejected"
+	closeReasonPanic    = "panic"
+)
+
+func (h *tcpServer) getAccessLog() bool {
+	if h.opts.Context == nil {
+		return false
+	}
+	b, _ := h.opts.Context.Value(accessLogKey{}).(bool)
+	return b
+}
+
+// logAccess writes access log record for closed connection
+func (h *tcpServer) logAccess(c *tcpConn) {
+	h.RLock()
+	config := h.opts
+	enabled := h.getAccessLog()
+	h.RUnlock()
+
+	if !enabled || !config.Logger.V(logger.InfoLevel) {
+		return
+	}
+
+	fields := []interface{}{
+		"conn_id", c.id,
+		"remote_addr", c.RemoteAddr().String(),
+		"local_addr", c.LocalAddr().String(),
+		"duration_seconds", time.Since(c.started).Seconds(),
+		"bytes_in", atomic.LoadUint64(&c.bytesIn),
+		"bytes_out", atomic.LoadUint64(&c.bytesOut),
+		"close_reason", c.closeReason(),
+	}
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		if st := tc.ConnectionState(); st.HandshakeComplete {
+			fields = append(fields,
+				"tls_version", tlsVersionName(st.Version),
+				"tls_cipher", tls.CipherSuiteName(st.CipherSuite),
+			)
+		}
+	}
+	if peer, ok := PeerFromContext(c.ctx); ok && peer != nil {
+		fields = append(fields, "peer_subject", peer.Subject)
+	}
+
+	config.Logger.Fields(fields...).Info(c.ctx, "tcp: connection closed")
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
@@ -0,0 +1,150 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// ErrConnDenied returned when remote address denied by allow or deny list
+var ErrConnDenied = errors.New("tcp: connection denied")
+
+// AdmitFunc called for each accepted connection or received datagram after
+// allow and deny lists checked, non nil error denies it
+type AdmitFunc func(ctx context.Context, addr net.Addr) error
+
+// accessList contains parsed allow and deny networks
+type accessList struct {
+	admit AdmitFunc
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// check returns reason if ip is denied
+func (a *accessList) check(ip net.IP) (string, bool) {
+	if ip == nil {
+		return "", true
+	}
+	if containsIP(a.deny, ip) {
+		return "deny", false
+	}
+	if len(a.allow) > 0 && !containsIP(a.allow, ip) {
+		return "allow", false
+	}
+	return "", true
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses networks, single addresses treated as /32 or /128 networks
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (h *tcpServer) getAccessList() (*accessList, error) {
+	acl := &accessList{}
+	if h.opts.Context == nil {
+		return acl, nil
+	}
+
+	var err error
+	if cidrs, ok := h.opts.Context.Value(allowCIDRKey{}).([]string); ok {
+		if acl.allow, err = parseCIDRs(cidrs); err != nil {
+			return nil, fmt.Errorf("invalid allow list: %v", err)
+		}
+	}
+	if cidrs, ok := h.opts.Context.Value(denyCIDRKey{}).([]string); ok {
+		if acl.deny, err = parseCIDRs(cidrs); err != nil {
+			return nil, fmt.Errorf("invalid deny list: %v", err)
+		}
+	}
+	acl.admit, _ = h.opts.Context.Value(admitHookKey{}).(AdmitFunc)
+
+	return acl, nil
+}
+
+// updateAccessList applies allow and deny lists, used connections not affected
+func (h *tcpServer) updateAccessList() error {
+	h.RLock()
+	acl, err := h.getAccessList()
+	h.RUnlock()
+	if err != nil {
+		return err
+	}
+	h.acl.Store(acl)
+	return nil
+}
+
+// admit checks remote address against allow and deny lists and admit hook,
+// denied addresses counted and logged
+func (h *tcpServer) admit(ctx context.Context, addr net.Addr) bool {
+	acl, _ := h.acl.Load().(*accessList)
+	if acl == nil {
+		return true
+	}
+
+	reason, ok := acl.check(remoteIP(addr))
+	var err error
+	if ok && acl.admit != nil {
+		if err = acl.admit(ctx, addr); err != nil {
+			reason, ok = "hook", false
+		}
+	}
+	if ok {
+		return true
+	}
+	if err == nil {
+		err = ErrConnDenied
+	}
+
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	config.Meter.Counter(metricConnDenied, metricLabels(config, "reason", reason)...).Inc()
+	if config.Logger.V(logger.DebugLevel) {
+		config.Logger.Debugf(config.Context, "tcp: %s denied: %v", addr, err)
+	}
+
+	return false
+}
+
+// admitConn checks access list and connection limits for remote address
+// of c, rejected connection closed
+func (h *tcpServer) admitConn(ctx context.Context, c net.Conn) (net.Conn, bool) {
+	if !h.admit(ctx, c.RemoteAddr()) {
+		_ = c.Close()
+		return nil, false
+	}
+	return h.limitConn(c)
+}
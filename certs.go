@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// DefaultCertReloadInterval specifies how often CertSource checked for new certificate
+var DefaultCertReloadInterval = time.Minute
+
+// CertSource provides server certificate for each tls handshake
+type CertSource interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// CertReloader implemented by CertSource that need periodic reload,
+// Reload returns true if certificate changed
+type CertReloader interface {
+	Reload() (bool, error)
+}
+
+// CertFunc is an adapter to allow the use of ordinary functions as CertSource,
+// for example to fetch certificates from external secret store
+type CertFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// GetCertificate calls f(hello)
+func (f CertFunc) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f(hello)
+}
+
+// FileCertSource loads certificate from cert and key files and reloads it
+// when files modified
+type FileCertSource struct {
+	cert     atomic.Value
+	certMod  time.Time
+	keyMod   time.Time
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+}
+
+var (
+	_ CertSource   = &FileCertSource{}
+	_ CertReloader = &FileCertSource{}
+)
+
+// NewFileCertSource creates FileCertSource and loads certificate
+func NewFileCertSource(certFile, keyFile string) (*FileCertSource, error) {
+	s := &FileCertSource{certFile: certFile, keyFile: keyFile}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetCertificate returns last loaded certificate
+func (s *FileCertSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, ok := s.cert.Load().(*tls.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("tcp: certificate %s not loaded", s.certFile)
+	}
+	return cert, nil
+}
+
+// Reload loads certificate if cert or key file modification time changed,
+// on error previous certificate continues to be used
+func (s *FileCertSource) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certInfo, err := os.Stat(s.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if certInfo.ModTime().Equal(s.certMod) && keyInfo.ModTime().Equal(s.keyMod) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	s.cert.Store(&cert)
+	s.certMod = certInfo.ModTime()
+	s.keyMod = keyInfo.ModTime()
+
+	return true, nil
+}
+
+func (h *tcpServer) getCertSource() CertSource {
+	if h.opts.Context == nil {
+		return nil
+	}
+	src, _ := h.opts.Context.Value(certSourceKey{}).(CertSource)
+	return src
+}
+
+// reloadCerts periodically reloads certificate source until ctx done
+func (h *tcpServer) reloadCerts(ctx context.Context, r CertReloader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.RLock()
+			config := h.opts
+			h.RUnlock()
+			reloaded, err := r.Reload()
+			switch {
+			case err != nil:
+				if config.Logger.V(logger.ErrorLevel) {
+					config.Logger.Errorf(config.Context, "tcp: certificate reload error: %v", err)
+				}
+			case reloaded:
+				if config.Logger.V(logger.InfoLevel) {
+					config.Logger.Infof(config.Context, "tcp: certificate reloaded")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+	"go.unistack.org/micro/v3/metadata"
+	"go.unistack.org/micro/v3/tracer"
+)
+
+// ErrConnNotFound returned when connection with specified id not exists
+var ErrConnNotFound = errors.New("tcp: connection not found")
+
+// ConnState represents the state of the connection
+type ConnState int32
+
+const (
+	// StateNew connection accepted but handler not started
+	StateNew ConnState = iota
+	// StateActive connection served by handler
+	StateActive
+	// StateDraining server stops and waits for connection to be closed
+	StateDraining
+	// StateClosed connection closed
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Conn is the net.Conn passed to Handler by the server
+type Conn interface {
+	net.Conn
+	// Context returns connection context, it cancelled when server stops
+	// or connection closed
+	Context() context.Context
+	// ID returns unique connection id
+	ID() string
+	// Metadata returns copy of connection metadata
+	Metadata() metadata.Metadata
+	// SetMetadata sets connection metadata key to value
+	SetMetadata(key, value string)
+}
+
+// ConnInfo contains connection details
+type ConnInfo struct {
+	Started    time.Time
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	Metadata   metadata.Metadata
+	ID         string
+	BytesIn    uint64
+	BytesOut   uint64
+	State      ConnState
+}
+
+// ConnManager implemented by the server and used to list and close
+// active connections
+type ConnManager interface {
+	// Conns returns info about all active connections ordered by start time
+	Conns() []ConnInfo
+	// ConnInfo returns info about connection with specified id
+	ConnInfo(id string) (ConnInfo, bool)
+	// CloseConn closes connection with specified id
+	CloseConn(id string) error
+}
+
+var _ ConnManager = &tcpServer{}
+
+type connKey struct{}
+
+// ConnFromContext returns Conn from handler context
+func ConnFromContext(ctx context.Context) (Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(Conn)
+	return c, ok
+}
+
+// ConnIDFromContext returns connection id from handler context
+func ConnIDFromContext(ctx context.Context) (string, bool) {
+	c, ok := ConnFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return c.ID(), true
+}
+
+type tcpConn struct {
+	bytesIn    uint64
+	bytesOut   uint64
+	lastActive int64
+	net.Conn
+	started      time.Time
+	ctx          context.Context
+	cancel       context.CancelFunc
+	s            *tcpServer
+	onClose      func()
+	md           metadata.Metadata
+	closeErr     error
+	id           string
+	reason       string
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	mu           sync.RWMutex
+	closeOnce    sync.Once
+	state        int32
+	// set when handler manages deadlines itself
+	readDeadline  int32
+	writeDeadline int32
+}
+
+func newConn(ctx context.Context, s *tcpServer, c net.Conn) *tcpConn {
+	cctx, cancel := context.WithCancel(ctx)
+	now := time.Now()
+	tc := &tcpConn{
+		Conn:       c,
+		ctx:        cctx,
+		cancel:     cancel,
+		s:          s,
+		started:    now,
+		lastActive: now.UnixNano(),
+		md:         metadata.New(0),
+	}
+	if s != nil {
+		tc.id = strconv.FormatUint(atomic.AddUint64(&s.connSeq, 1), 10)
+	}
+	tc.ctx = context.WithValue(cctx, connKey{}, tc)
+	return tc
+}
+
+// toConn returns c as Conn, if c created outside of the server it wrapped
+// with background context
+func toConn(c net.Conn) Conn {
+	if cc, ok := c.(Conn); ok {
+		return cc
+	}
+	return newConn(context.Background(), nil, c)
+}
+
+func (c *tcpConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *tcpConn) ID() string {
+	return c.id
+}
+
+func (c *tcpConn) Metadata() metadata.Metadata {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return metadata.Copy(c.md)
+}
+
+func (c *tcpConn) SetMetadata(key, value string) {
+	c.mu.Lock()
+	c.md[key] = value
+	c.mu.Unlock()
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 && atomic.LoadInt32(&c.readDeadline) == 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	}
+	if err != nil {
+		c.setCloseReason(c.ioCloseReason(err))
+	}
+	return n, err
+}
+
+func (c *tcpConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 && atomic.LoadInt32(&c.writeDeadline) == 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	}
+	if err != nil {
+		c.setCloseReason(c.ioCloseReason(err))
+	}
+	return n, err
+}
+
+// SetDeadline disables server managed read and write timeouts
+// while deadline is not zero
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.setUserDeadline(&c.readDeadline, t)
+	c.setUserDeadline(&c.writeDeadline, t)
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline disables server managed read timeout
+// while deadline is not zero
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.setUserDeadline(&c.readDeadline, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline disables server managed write timeout
+// while deadline is not zero
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.setUserDeadline(&c.writeDeadline, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *tcpConn) setUserDeadline(flag *int32, t time.Time) {
+	if t.IsZero() {
+		atomic.StoreInt32(flag, 0)
+	} else {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+// idle returns how long connection has no read or write activity
+func (c *tcpConn) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+}
+
+func (c *tcpConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.setState(StateClosed)
+		c.setCloseReason(closeReasonClosed)
+		c.closeErr = c.Conn.Close()
+		c.cancel()
+		if c.s != nil {
+			c.s.untrackConn(c)
+			c.s.connClosed(c)
+			c.s.logAccess(c)
+		}
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return c.closeErr
+}
+
+// closeWithReason closes connection, reason reported in access log
+func (c *tcpConn) closeWithReason(reason string) error {
+	c.setCloseReason(reason)
+	return c.Close()
+}
+
+// setCloseReason sets close reason if not set before
+func (c *tcpConn) setCloseReason(reason string) {
+	c.mu.Lock()
+	if c.reason == "" {
+		c.reason = reason
+	}
+	c.mu.Unlock()
+}
+
+func (c *tcpConn) closeReason() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.reason
+}
+
+// ioCloseReason returns close reason for read or write error,
+// errors after server stop caused by shutdown
+func (c *tcpConn) ioCloseReason(err error) string {
+	if c.ctx.Err() != nil {
+		return closeReasonShutdown
+	}
+	if err == io.EOF {
+		return closeReasonEOF
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return closeReasonTimeout
+	}
+	return closeReasonError
+}
+
+func (c *tcpConn) setState(state ConnState) {
+	atomic.StoreInt32(&c.state, int32(state))
+}
+
+func (c *tcpConn) info() ConnInfo {
+	return ConnInfo{
+		ID:         c.id,
+		RemoteAddr: c.RemoteAddr(),
+		LocalAddr:  c.LocalAddr(),
+		Started:    c.started,
+		BytesIn:    atomic.LoadUint64(&c.bytesIn),
+		BytesOut:   atomic.LoadUint64(&c.bytesOut),
+		State:      ConnState(atomic.LoadInt32(&c.state)),
+		Metadata:   c.Metadata(),
+	}
+}
+
+func (h *tcpServer) trackConn(c *tcpConn) {
+	h.connMu.Lock()
+	h.conns[c.id] = c
+	h.connMu.Unlock()
+}
+
+func (h *tcpServer) untrackConn(c *tcpConn) {
+	h.connMu.Lock()
+	delete(h.conns, c.id)
+	if len(h.conns) == 0 && h.connsDone != nil {
+		close(h.connsDone)
+		h.connsDone = nil
+	}
+	h.connMu.Unlock()
+}
+
+// waitConns returns channel closed when last tracked connection untracked
+func (h *tcpServer) waitConns() <-chan struct{} {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+	if len(h.conns) == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if h.connsDone == nil {
+		h.connsDone = make(chan struct{})
+	}
+	return h.connsDone
+}
+
+func (h *tcpServer) numConns() int {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+	return len(h.conns)
+}
+
+func (h *tcpServer) listConns() []*tcpConn {
+	h.connMu.Lock()
+	conns := make([]*tcpConn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.connMu.Unlock()
+	return conns
+}
+
+func (h *tcpServer) Conns() []ConnInfo {
+	conns := h.listConns()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+func (h *tcpServer) ConnInfo(id string) (ConnInfo, bool) {
+	h.connMu.Lock()
+	c, ok := h.conns[id]
+	h.connMu.Unlock()
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return c.info(), true
+}
+
+func (h *tcpServer) CloseConn(id string) error {
+	h.connMu.Lock()
+	c, ok := h.conns[id]
+	h.connMu.Unlock()
+	if !ok {
+		return ErrConnNotFound
+	}
+
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	if config.Logger.V(logger.InfoLevel) {
+		config.Logger.Infof(config.Context, "tcp: closing connection %s from %s", id, c.RemoteAddr())
+	}
+
+	return c.closeWithReason(closeReasonAdmin)
+}
+
+// connOptions applied to each accepted connection
+type connOptions struct {
+	tlsConfig    *tls.Config
+	framing      Framing
+	authorize    AuthorizeFunc
+	proxy        *proxyProtocol
+	maxMsgSize   int
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (h *tcpServer) newConnOptions() (connOptions, error) {
+	h.RLock()
+	defer h.RUnlock()
+
+	co := connOptions{
+		authorize:    h.getAuthorizeFunc(),
+		readTimeout:  h.getDuration(readTimeoutKey{}),
+		writeTimeout: h.getDuration(writeTimeoutKey{}),
+	}
+	co.framing, co.maxMsgSize = h.getFraming()
+
+	if h.opts.Context != nil {
+		if cidrs, ok := h.opts.Context.Value(proxyProtocolKey{}).([]string); ok {
+			proxy, err := newProxyProtocol(cidrs)
+			if err != nil {
+				return co, err
+			}
+			co.proxy = proxy
+		}
+	}
+
+	return co, nil
+}
+
+// rejectConn counts and closes connection rejected before handler started,
+// rejects caused by clients so logged at debug level
+func (h *tcpServer) rejectConn(c net.Conn, reason string, err error) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	config.Meter.Counter(metricConnRejected, metricLabels(config, "reason", reason)...).Inc()
+	if config.Logger.V(logger.DebugLevel) {
+		config.Logger.Debugf(config.Context, "tcp: connection from %s rejected: %v", c.RemoteAddr(), err)
+	}
+	if tc, ok := c.(*tcpConn); ok {
+		tc.setCloseReason(closeReasonRejected)
+	}
+	_ = c.Close()
+}
+
+// traceAcceptError records error of connection rejected before its span started
+func (h *tcpServer) traceAcceptError(ctx context.Context, err error) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	_, sp := config.Tracer.Start(ctx, "Accept", tracer.WithSpanKind(tracer.SpanKindServer))
+	sp.SetStatus(tracer.SpanStatusError, err.Error())
+	sp.Finish()
+}
+
+// serveConn prepares accepted connection and runs handler,
+// connection closed when handler returns or panics
+func (h *tcpServer) serveConn(ctx context.Context, c net.Conn, co connOptions, hd ContextHandler) {
+	var proxyHeader *ProxyHeader
+	if co.proxy != nil {
+		pc, err := co.proxy.accept(c)
+		if err != nil {
+			reason := "proxy"
+			if err == ErrUntrustedProxy {
+				reason = "untrusted_proxy"
+			}
+			h.traceAcceptError(ctx, err)
+			h.rejectConn(c, reason, err)
+			return
+		}
+		proxyHeader = pc.hdr
+		var ok bool
+		if c, ok = h.admitConn(ctx, pc); !ok {
+			return
+		}
+	}
+
+	if co.tlsConfig != nil {
+		c = tls.Server(c, co.tlsConfig)
+	}
+
+	tc := newConn(ctx, h, c)
+	tc.readTimeout = co.readTimeout
+	tc.writeTimeout = co.writeTimeout
+	if proxyHeader != nil {
+		tc.ctx = context.WithValue(tc.ctx, proxyHeaderKey{}, proxyHeader)
+	}
+
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	var sp tracer.Span
+	tc.ctx, sp = config.Tracer.Start(tc.ctx, "Conn",
+		tracer.WithSpanKind(tracer.SpanKindServer),
+		tracer.WithSpanLabels("conn.id", tc.id, "net.peer", tc.RemoteAddr().String(), "net.local", tc.LocalAddr().String()),
+	)
+	defer func() {
+		sp.AddLabels("bytes.in", atomic.LoadUint64(&tc.bytesIn), "bytes.out", atomic.LoadUint64(&tc.bytesOut))
+		sp.Finish()
+	}()
+	defer tc.Close()
+
+	defer func() {
+		if v := recover(); v != nil {
+			h.recoverPanic(tc.Context(), "handler", v)
+			sp.SetStatus(tracer.SpanStatusError, panicError(v).Error())
+			_ = tc.closeWithReason(closeReasonPanic)
+		}
+	}()
+
+	// handshake updates connection context, so done before connection
+	// tracked and visible to other goroutines
+	if err := h.handshake(tc); err != nil {
+		sp.SetStatus(tracer.SpanStatusError, err.Error())
+		h.rejectConn(tc, "tls", err)
+		return
+	}
+	if _, ok := tc.Conn.(*tls.Conn); ok && co.authorize != nil {
+		peer, _ := PeerFromContext(tc.ctx)
+		if err := co.authorize(tc.ctx, peer); err != nil {
+			sp.SetStatus(tracer.SpanStatusError, err.Error())
+			h.rejectConn(tc, "authorize", err)
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		_ = tc.closeWithReason(closeReasonShutdown)
+		return
+	}
+	h.trackConn(tc)
+
+	var conn Conn = tc
+	if co.framing != nil {
+		conn = newFrameConn(tc, co.framing, co.maxMsgSize)
+	}
+	tc.setState(StateActive)
+	hd.ServeConn(tc.Context(), conn)
+}
+
+// reapIdleConns periodically closes connections without activity
+// longer than timeout until ctx done
+func (h *tcpServer) reapIdleConns(ctx context.Context, timeout time.Duration) {
+	interval := timeout / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, c := range h.listConns() {
+				if c.idle(now) < timeout {
+					continue
+				}
+				h.RLock()
+				config := h.opts
+				h.RUnlock()
+				if config.Logger.V(logger.DebugLevel) {
+					config.Logger.Debugf(config.Context, "tcp: closing idle connection %s from %s", c.id, c.RemoteAddr())
+				}
+				config.Meter.Counter(metricConnIdle, metricLabels(config)...).Inc()
+				_ = c.closeWithReason(closeReasonIdle)
+			}
+		}
+	}
+}
+
+// drainConns waits for tracked connections to be closed by handlers,
+// after timeout all remaining connections closed forcibly
+func (h *tcpServer) drainConns(timeout time.Duration) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+
+	conns := h.listConns()
+	for _, c := range conns {
+		c.setState(StateDraining)
+		c.setCloseReason(closeReasonShutdown)
+	}
+
+	if n := len(conns); n > 0 && config.Logger.V(logger.InfoLevel) {
+		config.Logger.Infof(config.Context, "tcp: waiting up to %v for %d connections to close", timeout, n)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	select {
+	case <-h.waitConns():
+	case <-deadline.C:
+		conns = h.listConns()
+		if config.Logger.V(logger.InfoLevel) {
+			config.Logger.Infof(config.Context, "tcp: force closing %d connections", len(conns))
+		}
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+
+	"go.unistack.org/micro/v3/logger"
+	"go.unistack.org/micro/v3/server"
+)
+
+// EventHandler serves connections in event loop mode enabled by EventLoop
+// option. Callbacks for single connection never called concurrently,
+// data delivered only via OnData so Read must not be used,
+// b is valid only until OnData returns
+type EventHandler interface {
+	// OnOpen called when connection accepted, returned error closes it
+	OnOpen(c Conn) error
+	// OnData called when data read from the connection
+	OnData(c Conn, b []byte)
+	// OnClose called after connection closed, err is the read error if any
+	OnClose(c Conn, err error)
+}
+
+func (h *tcpServer) getEventLoop() bool {
+	h.RLock()
+	defer h.RUnlock()
+	if h.opts.Context == nil {
+		return false
+	}
+	_, ok := h.opts.Context.Value(eventLoopKey{}).(int)
+	return ok
+}
+
+func (h *tcpServer) getEventWorkers() int {
+	if h.opts.Context != nil {
+		if n, ok := h.opts.Context.Value(eventLoopKey{}).(int); ok && n > 0 {
+			return n
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// hasMaxConn reports whether MaxConn set for server or any listener
+func (h *tcpServer) hasMaxConn() bool {
+	h.RLock()
+	defer h.RUnlock()
+	if h.opts.Context == nil {
+		return false
+	}
+	if c, ok := h.opts.Context.Value(maxConnKey{}).(int); ok && c > 0 {
+		return true
+	}
+	extra, _ := h.opts.Context.Value(listenersKey{}).([]ListenerConfig)
+	for _, lc := range extra {
+		if lc.MaxConn > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// listenEvents opens stream listeners and returns func that starts event
+// loop and accepting connections, tls, framing and proxy protocol needs
+// buffered reads and not supported in event loop mode
+func (h *tcpServer) listenEvents(ctx context.Context, config server.Options, hd EventHandler) (func(context.Context), error) {
+	co, err := h.newConnOptions()
+	if err != nil {
+		return nil, err
+	}
+	if co.proxy != nil || co.framing != nil {
+		return nil, fmt.Errorf("event loop does not support proxy protocol and framing")
+	}
+	// connections of netutil.LimitListener hide file descriptor
+	if h.hasMaxConn() {
+		return nil, fmt.Errorf("event loop does not support MaxConn, use MaxConnPerIP or WorkerPool")
+	}
+
+	if err = h.updateConnLimits(); err != nil {
+		return nil, err
+	}
+	if err = h.updateAccessList(); err != nil {
+		return nil, err
+	}
+
+	h.RLock()
+	workers := h.getEventWorkers()
+	h.RUnlock()
+
+	loop, err := newEventLoop(h, hd, workers, co.maxMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners, err := h.openListeners(ctx, config, co)
+	if err != nil {
+		loop.close()
+		return nil, err
+	}
+	for _, l := range listeners {
+		if l.co.tlsConfig != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			loop.close()
+			return nil, fmt.Errorf("event loop does not support tls")
+		}
+	}
+
+	for _, l := range listeners {
+		if config.Logger.V(logger.ErrorLevel) {
+			config.Logger.Infof(config.Context, "Listening on %s", l.Addr().String())
+		}
+	}
+
+	h.Lock()
+	h.opts.Address = listenerAddress(listeners[0])
+	h.listeners = listeners
+	h.loop = loop
+	h.Unlock()
+
+	h.registerConnGauge(config)
+	pool := h.newWorkerPool(config)
+
+	return func(ctx context.Context) {
+		h.Lock()
+		h.loop = nil
+		h.Unlock()
+		loop.start(ctx)
+		if pool != nil {
+			pool.start(ctx)
+		}
+		for _, l := range listeners {
+			co := l.co
+			go h.serve(ctx, l, pool, func(c net.Conn) {
+				loop.add(ctx, c, co)
+			})
+		}
+	}, nil
+}
@@ -0,0 +1,264 @@
+//go:build linux
+// +build linux
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.unistack.org/micro/v3/tracer"
+)
+
+const eventFlags = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+// eventConn is the connection registered in event loop
+type eventConn struct {
+	*tcpConn
+	rc  syscall.RawConn
+	sp  tracer.Span
+	err error
+	fd  int
+}
+
+// eventLoop waits for readable connections with epoll and serves them by
+// fixed number of workers, connections registered in oneshot mode so
+// single connection served by one worker at a time
+type eventLoop struct {
+	s       *tcpServer
+	hd      EventHandler
+	conns   map[int]*eventConn
+	tasks   chan *eventConn
+	done    chan struct{}
+	epfd    int
+	workers int
+	bufSize int
+	mu      sync.Mutex
+	closed  bool
+}
+
+func newEventLoop(s *tcpServer, hd EventHandler, workers int, bufSize int) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLoop{
+		s:       s,
+		hd:      hd,
+		conns:   make(map[int]*eventConn),
+		tasks:   make(chan *eventConn, workers),
+		done:    make(chan struct{}),
+		epfd:    epfd,
+		workers: workers,
+		bufSize: bufSize,
+	}, nil
+}
+
+func (l *eventLoop) start(ctx context.Context) {
+	for i := 0; i < l.workers; i++ {
+		go l.work()
+	}
+	go l.poll(ctx)
+}
+
+func (l *eventLoop) close() {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		_ = syscall.Close(l.epfd)
+	}
+	l.mu.Unlock()
+}
+
+// active reports whether loop has registered connections
+func (l *eventLoop) active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.conns) > 0
+}
+
+// poll waits for epoll events until ctx done and all connections closed,
+// so connections still served while server drains them
+func (l *eventLoop) poll(ctx context.Context) {
+	defer close(l.done)
+	defer l.close()
+
+	events := make([]syscall.EpollEvent, 128)
+	for ctx.Err() == nil || l.active() {
+		n, err := syscall.EpollWait(l.epfd, events, 100)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			l.s.RLock()
+			config := l.s.opts
+			l.s.RUnlock()
+			config.Logger.Errorf(config.Context, "tcp: epoll wait error: %v", err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			l.mu.Lock()
+			ec := l.conns[int(events[i].Fd)]
+			l.mu.Unlock()
+			if ec != nil {
+				l.tasks <- ec
+			}
+		}
+	}
+}
+
+// work serves ready connections until poll exits
+func (l *eventLoop) work() {
+	buf := make([]byte, l.bufSize)
+	for {
+		select {
+		case <-l.done:
+			return
+		case ec := <-l.tasks:
+			l.serve(ec, buf)
+		}
+	}
+}
+
+// add registers accepted connection in event loop
+func (l *eventLoop) add(ctx context.Context, c net.Conn, co connOptions) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		l.s.rejectConn(c, "fd", fmt.Errorf("connection %T has no file descriptor", c))
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		l.s.rejectConn(c, "fd", err)
+		return
+	}
+
+	tc := newConn(ctx, l.s, c)
+	tc.readTimeout = co.readTimeout
+	tc.writeTimeout = co.writeTimeout
+	ec := &eventConn{tcpConn: tc, rc: rc, fd: -1}
+	if err = rc.Control(func(fd uintptr) { ec.fd = int(fd) }); err != nil {
+		l.s.rejectConn(c, "fd", err)
+		return
+	}
+
+	l.s.RLock()
+	config := l.s.opts
+	l.s.RUnlock()
+	tc.ctx, ec.sp = config.Tracer.Start(tc.ctx, "Conn",
+		tracer.WithSpanKind(tracer.SpanKindServer),
+		tracer.WithSpanLabels("conn.id", tc.id, "net.peer", tc.RemoteAddr().String(), "net.local", tc.LocalAddr().String()),
+	)
+	// span finished when connection closed
+	tc.onClose = func() {
+		l.remove(ec)
+		if ec.err != nil && ec.err != io.EOF {
+			ec.sp.SetStatus(tracer.SpanStatusError, ec.err.Error())
+		}
+		ec.sp.AddLabels("bytes.in", atomic.LoadUint64(&tc.bytesIn), "bytes.out", atomic.LoadUint64(&tc.bytesOut))
+		ec.sp.Finish()
+		l.hd.OnClose(tc, ec.err)
+	}
+	l.s.trackConn(tc)
+
+	defer func() {
+		if v := recover(); v != nil {
+			l.s.recoverPanic(tc.Context(), "handler", v)
+			ec.sp.SetStatus(tracer.SpanStatusError, panicError(v).Error())
+			_ = tc.closeWithReason(closeReasonPanic)
+		}
+	}()
+
+	if err = l.hd.OnOpen(tc); err != nil {
+		ec.sp.SetStatus(tracer.SpanStatusError, err.Error())
+		l.s.rejectConn(tc, "open", err)
+		return
+	}
+	tc.setState(StateActive)
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		_ = tc.closeWithReason(closeReasonShutdown)
+		return
+	}
+	l.conns[ec.fd] = ec
+	l.mu.Unlock()
+
+	// fails if handler closed connection in OnOpen
+	if err = l.ctl(ec, syscall.EPOLL_CTL_ADD); err != nil {
+		l.remove(ec)
+		ec.err = err
+		_ = tc.closeWithReason(closeReasonError)
+	}
+}
+
+// ctl adds or re-arms connection fd, fd can't be closed and reused while
+// Control callback runs
+func (l *eventLoop) ctl(ec *eventConn, op int) error {
+	var err error
+	if cerr := ec.rc.Control(func(fd uintptr) {
+		err = syscall.EpollCtl(l.epfd, op, int(fd), &syscall.EpollEvent{Events: eventFlags, Fd: int32(fd)})
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (l *eventLoop) remove(ec *eventConn) {
+	l.mu.Lock()
+	if l.conns[ec.fd] == ec {
+		delete(l.conns, ec.fd)
+	}
+	l.mu.Unlock()
+}
+
+// serve reads available data from the connection and calls OnData
+func (l *eventLoop) serve(ec *eventConn, buf []byte) {
+	tc := ec.tcpConn
+	defer func() {
+		if v := recover(); v != nil {
+			l.s.recoverPanic(tc.Context(), "handler", v)
+			ec.sp.SetStatus(tracer.SpanStatusError, panicError(v).Error())
+			_ = tc.closeWithReason(closeReasonPanic)
+		}
+	}()
+
+	var n int
+	var rerr error
+	err := ec.rc.Read(func(fd uintptr) bool {
+		n, rerr = syscall.Read(int(fd), buf)
+		// never wait in runtime poller, readiness reported by epoll
+		return true
+	})
+	if err == nil {
+		err = rerr
+	}
+
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+	case err != nil:
+		ec.err = err
+		_ = tc.closeWithReason(tc.ioCloseReason(err))
+		return
+	case n == 0:
+		ec.err = io.EOF
+		_ = tc.closeWithReason(closeReasonEOF)
+		return
+	default:
+		atomic.AddUint64(&tc.bytesIn, uint64(n))
+		atomic.StoreInt64(&tc.lastActive, time.Now().UnixNano())
+		l.hd.OnData(tc, buf[:n])
+	}
+
+	if err = l.ctl(ec, syscall.EPOLL_CTL_MOD); err != nil && tc.ctx.Err() == nil {
+		ec.err = err
+		_ = tc.closeWithReason(closeReasonError)
+	}
+}
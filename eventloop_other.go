@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+type eventLoop struct{}
+
+func newEventLoop(s *tcpServer, hd EventHandler, workers int, bufSize int) (*eventLoop, error) {
+	return nil, errors.New("event loop supported only on linux")
+}
+
+func (l *eventLoop) start(ctx context.Context) {}
+
+func (l *eventLoop) close() {}
+
+func (l *eventLoop) add(ctx context.Context, c net.Conn, co connOptions) {}
@@ -0,0 +1,115 @@
+package tcp
+
+import (
+	"net"
+	"os"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// inheritedFDStart is the first file descriptor passed to the process by
+// parent process or systemd
+const inheritedFDStart = 3
+
+// inheritFiles returns inherited file descriptors starting from 3 keyed by names
+func inheritFiles(names []string) map[string]*os.File {
+	files := make(map[string]*os.File, len(names))
+	for i, name := range names {
+		files[name] = os.NewFile(uintptr(inheritedFDStart+i), name)
+	}
+	return files
+}
+
+// loadInheritedFiles loads file descriptors passed by parent process on
+// restart or by systemd socket activation, single systemd socket not named
+// after any added listener used for the server Address
+func (h *tcpServer) loadInheritedFiles() {
+	h.Lock()
+	defer h.Unlock()
+	if h.inherited != nil {
+		return
+	}
+	var files map[string]*os.File
+	files, h.restartReady = restartFiles()
+	h.inherited = make(map[string]*os.File, len(files))
+	for name, f := range files {
+		h.inherited[name] = f
+	}
+	sdfiles := systemdFiles()
+	if len(sdfiles) == 1 {
+		for name, f := range sdfiles {
+			if !h.isListenerName(name) {
+				delete(sdfiles, name)
+				sdfiles[h.opts.Name] = f
+			}
+		}
+	}
+	for name, f := range sdfiles {
+		h.inherited[name] = f
+	}
+}
+
+// isListenerName reports whether name used by listener added via AddListener
+func (h *tcpServer) isListenerName(name string) bool {
+	if h.opts.Context == nil {
+		return false
+	}
+	extra, _ := h.opts.Context.Value(listenersKey{}).([]ListenerConfig)
+	for _, lc := range extra {
+		if lc.Name == name || (lc.Name == "" && lc.Address == name) || lc.Name+sdSocketSuffix == name {
+			return true
+		}
+	}
+	return false
+}
+
+// takeInheritedFile returns inherited file with name or systemd socket unit
+// named after it, file can be taken once
+func (h *tcpServer) takeInheritedFile(name string) (*os.File, bool) {
+	h.Lock()
+	defer h.Unlock()
+	for _, key := range []string{name, name + sdSocketSuffix} {
+		if f, ok := h.inherited[key]; ok {
+			delete(h.inherited, key)
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// closeInheritedFiles closes inherited files not used by any listener
+func (h *tcpServer) closeInheritedFiles() {
+	h.Lock()
+	files := h.inherited
+	h.inherited = make(map[string]*os.File)
+	config := h.opts
+	h.Unlock()
+	for name, f := range files {
+		if config.Logger.V(logger.InfoLevel) {
+			config.Logger.Infof(config.Context, "tcp: inherited socket %s not used by any listener, closing", name)
+		}
+		_ = f.Close()
+	}
+}
+
+// inheritedListener returns listener created from inherited file with name,
+// nil returned if there is no such file
+func (h *tcpServer) inheritedListener(name string) (net.Listener, error) {
+	f, ok := h.takeInheritedFile(name)
+	if !ok {
+		return nil, nil
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// inheritedPacketConn returns packet conn created from inherited file with
+// name, nil returned if there is no such file
+func (h *tcpServer) inheritedPacketConn(name string) (net.PacketConn, error) {
+	f, ok := h.takeInheritedFile(name)
+	if !ok {
+		return nil, nil
+	}
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
@@ -0,0 +1,202 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+var (
+	// ErrFrameTooLarge returned when frame size exceeds maximum message size
+	ErrFrameTooLarge = errors.New("tcp: frame too large")
+	// ErrInvalidFrame returned when frame length prefix can't be decoded
+	ErrInvalidFrame = errors.New("tcp: invalid frame")
+)
+
+// PrefixSize specifies the length prefix encoding used by LengthFraming
+type PrefixSize int
+
+const (
+	// PrefixUint16 encodes frame length as fixed 2 byte integer
+	PrefixUint16 PrefixSize = iota
+	// PrefixUint32 encodes frame length as fixed 4 byte integer
+	PrefixUint32
+	// PrefixVarint encodes frame length as unsigned varint
+	PrefixVarint
+)
+
+// Framing splits byte stream into frames
+type Framing interface {
+	// ReadFrame reads single frame, frames larger then maxSize must be rejected
+	ReadFrame(r *bufio.Reader, maxSize int) ([]byte, error)
+	// WriteFrame writes single frame, frames larger then maxSize must be rejected
+	WriteFrame(w io.Writer, b []byte, maxSize int) error
+}
+
+// FrameConn passed to Handler when Framer option used
+type FrameConn interface {
+	Conn
+	// ReadFrame returns next whole frame from the connection
+	ReadFrame() ([]byte, error)
+	// WriteFrame writes b as a single frame to the connection
+	WriteFrame(b []byte) error
+}
+
+type lengthFraming struct {
+	order binary.ByteOrder
+	size  PrefixSize
+}
+
+// LengthFraming returns Framing that prefix each frame with its length,
+// if order is nil binary.BigEndian used
+func LengthFraming(size PrefixSize, order binary.ByteOrder) Framing {
+	if order == nil {
+		order = binary.BigEndian
+	}
+	return &lengthFraming{size: size, order: order}
+}
+
+func (f *lengthFraming) ReadFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var n uint64
+
+	switch f.size {
+	case PrefixUint16:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		n = uint64(f.order.Uint16(buf))
+	case PrefixUint32:
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		n = uint64(f.order.Uint32(buf))
+	case PrefixVarint:
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			return nil, ErrInvalidFrame
+		}
+		n = v
+	default:
+		return nil, fmt.Errorf("tcp: unknown prefix size %d", f.size)
+	}
+
+	if maxSize > 0 && n > uint64(maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func (f *lengthFraming) WriteFrame(w io.Writer, b []byte, maxSize int) error {
+	if maxSize > 0 && len(b) > maxSize {
+		return ErrFrameTooLarge
+	}
+
+	var buf []byte
+
+	switch f.size {
+	case PrefixUint16:
+		if len(b) > 0xffff {
+			return ErrFrameTooLarge
+		}
+		buf = make([]byte, 2, 2+len(b))
+		f.order.PutUint16(buf, uint16(len(b)))
+	case PrefixUint32:
+		if uint64(len(b)) > 0xffffffff {
+			return ErrFrameTooLarge
+		}
+		buf = make([]byte, 4, 4+len(b))
+		f.order.PutUint32(buf, uint32(len(b)))
+	case PrefixVarint:
+		buf = make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(b))
+		buf = buf[:binary.PutUvarint(buf, uint64(len(b)))]
+	default:
+		return fmt.Errorf("tcp: unknown prefix size %d", f.size)
+	}
+
+	// single write to not interleave prefix and payload
+	_, err := w.Write(append(buf, b...))
+	return err
+}
+
+type frameConn struct {
+	Conn
+	framing Framing
+	br      *bufio.Reader
+	pending []byte
+	maxSize int
+	rmu     sync.Mutex
+	wmu     sync.Mutex
+}
+
+func newFrameConn(c net.Conn, f Framing, maxSize int) *frameConn {
+	cc := toConn(c)
+	return &frameConn{
+		Conn:    cc,
+		framing: f,
+		br:      bufio.NewReader(cc),
+		maxSize: maxSize,
+	}
+}
+
+func (c *frameConn) ReadFrame() ([]byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.pending != nil {
+		b := c.pending
+		c.pending = nil
+		return b, nil
+	}
+	return c.framing.ReadFrame(c.br, c.maxSize)
+}
+
+func (c *frameConn) WriteFrame(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framing.WriteFrame(c.Conn, b, c.maxSize)
+}
+
+// Read reads whole frame into b, if b is too small to hold the frame
+// io.ErrShortBuffer returned and frame kept for the next Read
+func (c *frameConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	frame := c.pending
+	if frame == nil {
+		var err error
+		if frame, err = c.framing.ReadFrame(c.br, c.maxSize); err != nil {
+			return 0, err
+		}
+	}
+	if len(frame) > len(b) {
+		c.pending = frame
+		return 0, io.ErrShortBuffer
+	}
+	c.pending = nil
+	return copy(b, frame), nil
+}
+
+// Write writes b as a single frame
+func (c *frameConn) Write(b []byte) (int, error) {
+	if err := c.WriteFrame(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
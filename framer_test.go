@@ -0,0 +1,144 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestLengthFramingRoundTrip(t *testing.T) {
+	frames := [][]byte{
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte("b"), 127),
+		bytes.Repeat([]byte("c"), 128),
+		bytes.Repeat([]byte("d"), 0xffff),
+	}
+
+	tests := []struct {
+		name  string
+		size  PrefixSize
+		order binary.ByteOrder
+	}{
+		{name: "uint16", size: PrefixUint16},
+		{name: "uint16 little endian", size: PrefixUint16, order: binary.LittleEndian},
+		{name: "uint32", size: PrefixUint32},
+		{name: "uint32 little endian", size: PrefixUint32, order: binary.LittleEndian},
+		{name: "varint", size: PrefixVarint},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := LengthFraming(tt.size, tt.order)
+			buf := bytes.NewBuffer(nil)
+			for _, b := range frames {
+				if err := f.WriteFrame(buf, b, 0xffff); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := bufio.NewReader(buf)
+			for i, want := range frames {
+				b, err := f.ReadFrame(r, 0xffff)
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(b, want) {
+					t.Fatalf("frame %d has %d bytes, want %d", i, len(b), len(want))
+				}
+			}
+			if _, err := f.ReadFrame(r, 0xffff); err != io.EOF {
+				t.Fatalf("error %v, want %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestLengthFramingPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   PrefixSize
+		order  binary.ByteOrder
+		prefix []byte
+	}{
+		{name: "uint16", size: PrefixUint16, prefix: []byte{0x01, 0x2c}},
+		{name: "uint16 little endian", size: PrefixUint16, order: binary.LittleEndian, prefix: []byte{0x2c, 0x01}},
+		{name: "uint32", size: PrefixUint32, prefix: []byte{0, 0, 0x01, 0x2c}},
+		{name: "varint", size: PrefixVarint, prefix: []byte{0xac, 0x02}},
+	}
+
+	payload := bytes.Repeat([]byte("x"), 300)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			if err := LengthFraming(tt.size, tt.order).WriteFrame(buf, payload, 0); err != nil {
+				t.Fatal(err)
+			}
+			if b := buf.Bytes()[:len(tt.prefix)]; !bytes.Equal(b, tt.prefix) {
+				t.Fatalf("prefix %x, want %x", b, tt.prefix)
+			}
+			if n := buf.Len(); n != len(tt.prefix)+len(payload) {
+				t.Fatalf("frame has %d bytes, want %d", n, len(tt.prefix)+len(payload))
+			}
+		})
+	}
+}
+
+func TestLengthFramingReadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    PrefixSize
+		in      []byte
+		maxSize int
+		err     error
+	}{
+		{name: "uint16 empty", size: PrefixUint16, err: io.EOF},
+		{name: "uint16 truncated prefix", size: PrefixUint16, in: []byte{0}, err: io.ErrUnexpectedEOF},
+		{name: "uint16 truncated payload", size: PrefixUint16, in: []byte{0, 3, 'a'}, err: io.ErrUnexpectedEOF},
+		{name: "uint16 missing payload", size: PrefixUint16, in: []byte{0, 3}, err: io.ErrUnexpectedEOF},
+		{name: "uint16 oversize", size: PrefixUint16, in: []byte{0, 5, 'a', 'b', 'c', 'd', 'e'}, maxSize: 4, err: ErrFrameTooLarge},
+		{name: "uint32 truncated prefix", size: PrefixUint32, in: []byte{0, 0, 0}, err: io.ErrUnexpectedEOF},
+		{name: "uint32 oversize", size: PrefixUint32, in: []byte{0xff, 0xff, 0xff, 0xff}, maxSize: 1024, err: ErrFrameTooLarge},
+		{name: "varint empty", size: PrefixVarint, err: io.EOF},
+		{name: "varint truncated prefix", size: PrefixVarint, in: []byte{0x80}, err: io.ErrUnexpectedEOF},
+		{name: "varint oversize", size: PrefixVarint, in: []byte{0xac, 0x02}, maxSize: 299, err: ErrFrameTooLarge},
+		{name: "varint max uint64", size: PrefixVarint, in: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, maxSize: 1024, err: ErrFrameTooLarge},
+		{name: "varint overflow", size: PrefixVarint, in: bytes.Repeat([]byte{0xff}, 11), maxSize: 1024, err: ErrInvalidFrame},
+		{name: "varint overflow last byte", size: PrefixVarint, in: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, maxSize: 1024, err: ErrInvalidFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LengthFraming(tt.size, nil).ReadFrame(bufio.NewReader(bytes.NewReader(tt.in)), tt.maxSize)
+			if err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLengthFramingWriteErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    PrefixSize
+		len     int
+		maxSize int
+	}{
+		{name: "oversize", size: PrefixUint32, len: 5, maxSize: 4},
+		{name: "uint16 prefix overflow", size: PrefixUint16, len: 0x10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			err := LengthFraming(tt.size, nil).WriteFrame(buf, make([]byte, tt.len), tt.maxSize)
+			if err != ErrFrameTooLarge {
+				t.Fatalf("error %v, want %v", err, ErrFrameTooLarge)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes written", buf.Len())
+			}
+		})
+	}
+}
@@ -1,19 +1,61 @@
 package tcp
 
 import (
+	"context"
 	"net"
 
 	"go.unistack.org/micro/v3/register"
 	"go.unistack.org/micro/v3/server"
 )
 
+// Handler serves accepted connection, the server owns the connection
+// and closes it when Serve returns
 type Handler interface {
 	Serve(net.Conn)
 }
 
+// HandlerFunc is an adapter to allow the use of ordinary functions as Handler
+type HandlerFunc func(net.Conn)
+
+// Serve calls f(c)
+func (f HandlerFunc) Serve(c net.Conn) {
+	f(c)
+}
+
+// ContextHandler serves connection with context that carries server logger,
+// tracer, meter and the Conn, context cancelled when server stops
+type ContextHandler interface {
+	ServeConn(ctx context.Context, conn net.Conn)
+}
+
+// ContextHandlerFunc is an adapter to allow the use of ordinary functions
+// as ContextHandler
+type ContextHandlerFunc func(ctx context.Context, conn net.Conn)
+
+// ServeConn calls f(ctx, c)
+func (f ContextHandlerFunc) ServeConn(ctx context.Context, c net.Conn) {
+	f(ctx, c)
+}
+
+// ConnWrapper wraps connection handler, used to apply middleware such as
+// logging, auth or rate limiting to all connections. Wrapper may replace
+// the conn passed to the next handler, ctx keeps the server connection
+// context so it must be passed through
+type ConnWrapper func(ContextHandler) ContextHandler
+
+// connHandler serves connections of Handler in the wrappers chain
+type connHandler struct {
+	hd Handler
+}
+
+func (h *connHandler) ServeConn(ctx context.Context, c net.Conn) {
+	h.hd.Serve(c)
+}
+
 type tcpHandler struct {
 	opts       server.HandlerOptions
 	hd         interface{}
+	svc        *rpcService
 	eps        []*register.Endpoint
 	maxMsgSize int
 }
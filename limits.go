@@ -0,0 +1,240 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+var (
+	// ErrConnLimit returned when remote address exceeds concurrent connection limit
+	ErrConnLimit = errors.New("tcp: connection limit exceeded")
+	// ErrRateLimit returned when accept rate limit exceeded
+	ErrRateLimit = errors.New("tcp: accept rate limit exceeded")
+)
+
+// cidrLimit limits concurrent connections from all addresses of the network
+type cidrLimit struct {
+	cidr string
+	max  int
+}
+
+type acceptRate struct {
+	rate  float64
+	burst int
+}
+
+// connLimits contains parsed limit options
+type connLimits struct {
+	nets  []*net.IPNet
+	max   []int
+	rate  float64
+	burst int
+	perIP int
+}
+
+// connLimiter tracks concurrent connections per remote ip and network and
+// limits accept rate with token bucket
+type connLimiter struct {
+	last   time.Time
+	ips    map[string]int
+	nets   map[string]int
+	limits connLimits
+	tokens float64
+	mu     sync.Mutex
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		ips:  make(map[string]int),
+		nets: make(map[string]int),
+	}
+}
+
+// update replaces limits, current connection counters and tokens kept
+func (l *connLimiter) update(limits connLimits) {
+	l.mu.Lock()
+	if limits.rate != l.limits.rate || limits.burst != l.limits.burst {
+		if l.last.IsZero() || l.tokens > float64(limits.burst) {
+			l.tokens = float64(limits.burst)
+		}
+	}
+	l.limits = limits
+	l.mu.Unlock()
+}
+
+// acquire reserves connection slot for remote address of c, returned conn releases it on close
+func (l *connLimiter) acquire(c net.Conn) (net.Conn, string, error) {
+	ip := remoteIP(c.RemoteAddr())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.rate > 0 {
+		now := time.Now()
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.limits.rate
+			if burst := float64(l.limits.burst); l.tokens > burst {
+				l.tokens = burst
+			}
+		}
+		l.last = now
+		if l.tokens < 1 {
+			return nil, "rate", ErrRateLimit
+		}
+	}
+
+	var key string
+	var nets []string
+	if ip != nil {
+		key = ip.String()
+		if l.limits.perIP > 0 && l.ips[key] >= l.limits.perIP {
+			return nil, "ip", ErrConnLimit
+		}
+		for i, n := range l.limits.nets {
+			if !n.Contains(ip) {
+				continue
+			}
+			if l.nets[n.String()] >= l.limits.max[i] {
+				return nil, "cidr", ErrConnLimit
+			}
+			nets = append(nets, n.String())
+		}
+	}
+
+	if l.limits.rate > 0 {
+		l.tokens--
+	}
+	if ip == nil {
+		return c, "", nil
+	}
+
+	l.ips[key]++
+	for _, n := range nets {
+		l.nets[n]++
+	}
+
+	return &limitedConn{Conn: c, l: l, ip: key, nets: nets}, "", nil
+}
+
+func (l *connLimiter) release(ip string, nets []string) {
+	l.mu.Lock()
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+	for _, n := range nets {
+		if l.nets[n]--; l.nets[n] <= 0 {
+			delete(l.nets, n)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// limitedConn releases limiter slot when closed
+type limitedConn struct {
+	net.Conn
+	l    *connLimiter
+	ip   string
+	nets []string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.l.release(c.ip, c.nets)
+	})
+	return err
+}
+
+// SyscallConn returns raw connection of wrapped conn, used by event loop
+func (c *limitedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("connection %T has no file descriptor", c.Conn)
+	}
+	return sc.SyscallConn()
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// getConnLimits parses limit options
+func (h *tcpServer) getConnLimits() (connLimits, error) {
+	var limits connLimits
+	if h.opts.Context == nil {
+		return limits, nil
+	}
+
+	limits.perIP, _ = h.opts.Context.Value(maxConnPerIPKey{}).(int)
+	if rl, ok := h.opts.Context.Value(acceptRateKey{}).(acceptRate); ok && rl.rate > 0 {
+		limits.rate = rl.rate
+		limits.burst = rl.burst
+		if limits.burst < 1 {
+			limits.burst = 1
+		}
+	}
+
+	// same network written differently limited once by the last limit
+	cidrs, _ := h.opts.Context.Value(maxConnPerCIDRKey{}).([]cidrLimit)
+	idx := make(map[string]int, len(cidrs))
+	for _, cl := range cidrs {
+		nets, err := parseCIDRs([]string{cl.cidr})
+		if err != nil {
+			return limits, fmt.Errorf("invalid connection limit cidr %q: %v", cl.cidr, err)
+		}
+		if i, ok := idx[nets[0].String()]; ok {
+			limits.max[i] = cl.max
+			continue
+		}
+		idx[nets[0].String()] = len(limits.nets)
+		limits.nets = append(limits.nets, nets[0])
+		limits.max = append(limits.max, cl.max)
+	}
+
+	return limits, nil
+}
+
+// updateConnLimits applies limit options to the connection limiter
+func (h *tcpServer) updateConnLimits() error {
+	h.RLock()
+	limits, err := h.getConnLimits()
+	h.RUnlock()
+	if err != nil {
+		return err
+	}
+	h.limiter.update(limits)
+	return nil
+}
+
+// limitConn checks connection limits for accepted connection,
+// rejected connections counted and closed
+func (h *tcpServer) limitConn(c net.Conn) (net.Conn, bool) {
+	lc, reason, err := h.limiter.acquire(c)
+	if err == nil {
+		return lc, true
+	}
+
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	config.Meter.Counter(metricConnRejected, metricLabels(config, "reason", reason)...).Inc()
+	if config.Logger.V(logger.DebugLevel) {
+		config.Logger.Debugf(config.Context, "tcp: connection from %s rejected: %v", c.RemoteAddr(), err)
+	}
+	_ = c.Close()
+
+	return nil, false
+}
@@ -0,0 +1,131 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *addrConn) Close() error {
+	return nil
+}
+
+func newAddrConn(ip string) net.Conn {
+	return &addrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+func mustAcquire(t *testing.T, l *connLimiter, ip string) net.Conn {
+	t.Helper()
+	c, reason, err := l.acquire(newAddrConn(ip))
+	if err != nil {
+		t.Fatalf("acquire %s: %v (%s)", ip, err, reason)
+	}
+	return c
+}
+
+func mustReject(t *testing.T, l *connLimiter, ip string, want error, wantReason string) {
+	t.Helper()
+	_, reason, err := l.acquire(newAddrConn(ip))
+	if err != want || reason != wantReason {
+		t.Fatalf("acquire %s: error %v (%s), want %v (%s)", ip, err, reason, want, wantReason)
+	}
+}
+
+func TestConnLimiterPerIP(t *testing.T) {
+	l := newConnLimiter()
+	l.update(connLimits{perIP: 2})
+
+	c1 := mustAcquire(t, l, "1.1.1.1")
+	mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrConnLimit, "ip")
+	mustAcquire(t, l, "2.2.2.2")
+
+	_ = c1.Close()
+	mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrConnLimit, "ip")
+}
+
+func TestConnLimiterCIDR(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	l := newConnLimiter()
+	l.update(connLimits{nets: []*net.IPNet{n}, max: []int{2}})
+
+	c1 := mustAcquire(t, l, "10.0.0.1")
+	mustAcquire(t, l, "10.0.0.2")
+	mustReject(t, l, "10.0.0.3", ErrConnLimit, "cidr")
+	mustAcquire(t, l, "11.0.0.1")
+
+	_ = c1.Close()
+	mustAcquire(t, l, "10.0.0.3")
+	if v := l.nets[n.String()]; v != 2 {
+		t.Fatalf("network counter %d, want 2", v)
+	}
+}
+
+func TestConnLimiterRate(t *testing.T) {
+	l := newConnLimiter()
+	l.update(connLimits{rate: 10, burst: 2})
+
+	mustAcquire(t, l, "1.1.1.1")
+	mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrRateLimit, "rate")
+
+	// 10 per second refills 2 tokens in 200ms, bucket capped by burst
+	l.last = l.last.Add(-time.Second)
+	mustAcquire(t, l, "1.1.1.1")
+	mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrRateLimit, "rate")
+
+	l.last = l.last.Add(-200 * time.Millisecond)
+	mustAcquire(t, l, "1.1.1.1")
+}
+
+func TestConnLimiterUpdate(t *testing.T) {
+	l := newConnLimiter()
+	l.update(connLimits{perIP: 1})
+
+	c1 := mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrConnLimit, "ip")
+
+	// raising limit keeps current connections counted
+	l.update(connLimits{perIP: 2})
+	c2 := mustAcquire(t, l, "1.1.1.1")
+	mustReject(t, l, "1.1.1.1", ErrConnLimit, "ip")
+
+	l.update(connLimits{perIP: 1})
+	_ = c2.Close()
+	mustReject(t, l, "1.1.1.1", ErrConnLimit, "ip")
+	_ = c1.Close()
+	mustAcquire(t, l, "1.1.1.1")
+
+	// removed limit not checked
+	l.update(connLimits{})
+	mustAcquire(t, l, "1.1.1.1")
+}
+
+func TestGetConnLimitsCIDR(t *testing.T) {
+	h := NewServer(
+		MaxConnPerCIDR("10.0.0.0/8", 5),
+		MaxConnPerCIDR("192.168.0.0/16", 1),
+		MaxConnPerCIDR("10.0.0.0/8", 10),
+		MaxConnPerCIDR("10.1.2.3/8", 20),
+		MaxConnPerCIDR("192.168.0.0/16", 0),
+	).(*tcpServer)
+
+	limits, err := h.getConnLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits.nets) != 1 || limits.nets[0].String() != "10.0.0.0/8" || limits.max[0] != 20 {
+		t.Fatalf("limits %v %v, want [10.0.0.0/8] [20]", limits.nets, limits.max)
+	}
+}
@@ -0,0 +1,273 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const unixScheme = "unix://"
+
+// parseAddress returns network and address, unix:///path/to.sock and
+// unix://@name addresses used for unix domain and abstract sockets
+func parseAddress(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixScheme) {
+		return "unix", strings.TrimPrefix(addr, unixScheme)
+	}
+	return "tcp", addr
+}
+
+// listenerAddress returns listener address in form accepted by parseAddress
+func listenerAddress(ln net.Listener) string {
+	addr := ln.Addr()
+	if addr.Network() == "unix" {
+		return unixScheme + addr.String()
+	}
+	return addr.String()
+}
+
+// ListenerConfig describes additional listener served by the same handler
+type ListenerConfig struct {
+	// Listener used instead of listening on Address
+	Listener net.Listener
+	// TLSConfig enables tls on the listener
+	TLSConfig *tls.Config
+	// Name identifies listener in register metadata, address used if empty
+	Name string
+	// Address to listen on, unix:// prefix used for unix domain sockets
+	Address string
+	// MaxConn limits number of concurrent connections on the listener
+	MaxConn int
+}
+
+// serverListener is the listener opened on Start with its connection options,
+// raw is the listener opened by the server and passed to restarted process
+type serverListener struct {
+	net.Listener
+	raw  net.Listener
+	name string
+	co   connOptions
+	// set when listener closed by the server, so accept error expected
+	closed int32
+}
+
+func (l *serverListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return l.Listener.Close()
+}
+
+func (l *serverListener) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
+
+// sockOptions contains socket options applied to listener and accepted connections
+type sockOptions struct {
+	noDelay     *bool
+	linger      *int
+	keepAlive   time.Duration
+	readBuffer  int
+	writeBuffer int
+	reusePort   bool
+}
+
+func (h *tcpServer) getSockOptions() sockOptions {
+	var so sockOptions
+	if h.opts.Context == nil {
+		return so
+	}
+	if v, ok := h.opts.Context.Value(keepAliveKey{}).(time.Duration); ok {
+		so.keepAlive = v
+	}
+	if v, ok := h.opts.Context.Value(noDelayKey{}).(bool); ok {
+		so.noDelay = &v
+	}
+	if v, ok := h.opts.Context.Value(lingerKey{}).(int); ok {
+		so.linger = &v
+	}
+	if v, ok := h.opts.Context.Value(readBufferKey{}).(int); ok {
+		so.readBuffer = v
+	}
+	if v, ok := h.opts.Context.Value(writeBufferKey{}).(int); ok {
+		so.writeBuffer = v
+	}
+	if v, ok := h.opts.Context.Value(reusePortKey{}).(bool); ok {
+		so.reusePort = v
+	}
+	return so
+}
+
+// control sets listener socket options before bind
+func (so sockOptions) control(network, address string, rc syscall.RawConn) error {
+	var err error
+	if cerr := rc.Control(func(fd uintptr) {
+		err = setSockOptions(fd, so)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// apply sets per connection socket options
+func (so sockOptions) apply(c net.Conn) error {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if so.noDelay != nil {
+		if err := tc.SetNoDelay(*so.noDelay); err != nil {
+			return err
+		}
+	}
+	if so.linger != nil {
+		if err := tc.SetLinger(*so.linger); err != nil {
+			return err
+		}
+	}
+	if so.readBuffer > 0 {
+		if err := tc.SetReadBuffer(so.readBuffer); err != nil {
+			return err
+		}
+	}
+	if so.writeBuffer > 0 {
+		if err := tc.SetWriteBuffer(so.writeBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sockoptListener applies socket options to accepted connections
+type sockoptListener struct {
+	net.Listener
+	opts sockOptions
+}
+
+func (l *sockoptListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err = l.opts.apply(c); err != nil {
+		_ = c.Close()
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.Addr(), Err: &tempError{err}}
+	}
+	return c, nil
+}
+
+// unwrapListener returns listener wrapped by sockoptListener
+func unwrapListener(ln net.Listener) net.Listener {
+	if sl, ok := ln.(*sockoptListener); ok {
+		return sl.Listener
+	}
+	return ln
+}
+
+// tempError marks error as temporary so accept loop continues
+type tempError struct {
+	error
+}
+
+func (e *tempError) Temporary() bool { return true }
+func (e *tempError) Timeout() bool   { return false }
+
+// listen creates tcp or unix listener with specified socket options
+func (h *tcpServer) listen(ctx context.Context, address string, so sockOptions) (net.Listener, error) {
+	network, address := parseAddress(address)
+	if network == "unix" {
+		return h.listenUnix(ctx, address)
+	}
+
+	lc := &net.ListenConfig{
+		KeepAlive: so.keepAlive,
+		Control:   so.control,
+	}
+	ln, err := lc.Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &sockoptListener{Listener: ln, opts: so}, nil
+}
+
+// listenUnix creates unix domain socket listener, stale socket file removed
+// and file mode and owner applied, names starting with @ are abstract sockets
+func (h *tcpServer) listenUnix(ctx context.Context, path string) (net.Listener, error) {
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var mode os.FileMode
+	var owner [2]int
+	var hasMode, hasOwner bool
+	h.RLock()
+	if h.opts.Context != nil {
+		mode, hasMode = h.opts.Context.Value(socketModeKey{}).(os.FileMode)
+		owner, hasOwner = h.opts.Context.Value(socketOwnerKey{}).([2]int)
+	}
+	h.RUnlock()
+
+	lc := &net.ListenConfig{}
+	if abstract || (!hasMode && !hasOwner) {
+		return lc.Listen(ctx, "unix", path)
+	}
+
+	// socket file created under restrictive umask, so it never connectable
+	// with default permissions before mode and owner applied
+	mask := 0177
+	if hasMode {
+		mask = int(^mode.Perm() & os.ModePerm)
+	}
+	var ln net.Listener
+	umask, err := withUmask(mask, func() error {
+		var lerr error
+		ln, lerr = lc.Listen(ctx, "unix", path)
+		return lerr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hasOwner {
+		if err = os.Chown(path, owner[0], owner[1]); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	if !hasMode {
+		mode = os.ModePerm &^ os.FileMode(umask)
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// removeStaleSocket removes socket file left by previous process,
+// it fails if socket still accepts connections or path is not a socket
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("tcp: %s exists and is not a socket", path)
+	}
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = c.Close()
+		return fmt.Errorf("tcp: socket %s already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,60 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.unistack.org/micro/v3/server"
+)
+
+// metric names, all metrics labelled with server name and id
+const (
+	metricConnAccepted   = "micro_server_tcp_conn_accepted_total"
+	metricConnActive     = "micro_server_tcp_conn_active"
+	metricConnRejected   = "micro_server_tcp_conn_rejected_total"
+	metricConnDenied     = "micro_server_tcp_conn_denied_total"
+	metricConnIdle       = "micro_server_tcp_conn_idle_closed_total"
+	metricConnDuration   = "micro_server_tcp_conn_duration_seconds"
+	metricAcceptErrors   = "micro_server_tcp_accept_errors_total"
+	metricBytesRead      = "micro_server_tcp_read_bytes_total"
+	metricBytesWritten   = "micro_server_tcp_written_bytes_total"
+	metricPackets        = "micro_server_tcp_packets_total"
+	metricRequests       = "micro_server_tcp_request_total"
+	metricRequestLatency = "micro_server_tcp_request_latency_seconds"
+	metricSubMessages    = "micro_server_tcp_subscriber_messages_total"
+	metricSubLatency     = "micro_server_tcp_subscriber_latency_seconds"
+	metricPoolQueue      = "micro_server_tcp_pool_queue_depth"
+	metricPanics         = "micro_server_tcp_panic_total"
+)
+
+// metricLabels returns labels prefixed with server name and id
+func metricLabels(config server.Options, labels ...string) []string {
+	return append([]string{"server", config.Name, "id", config.ID}, labels...)
+}
+
+// metricStatus returns status label value for handler error
+func metricStatus(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// registerConnGauge registers gauge with number of active connections
+func (h *tcpServer) registerConnGauge(config server.Options) {
+	config.Meter.Gauge(metricConnActive, func() float64 {
+		return float64(h.numConns())
+	}, metricLabels(config)...)
+}
+
+// connClosed records traffic and duration of closed connection
+func (h *tcpServer) connClosed(c *tcpConn) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+
+	labels := metricLabels(config)
+	config.Meter.Counter(metricBytesRead, labels...).Add(int(atomic.LoadUint64(&c.bytesIn)))
+	config.Meter.Counter(metricBytesWritten, labels...).Add(int(atomic.LoadUint64(&c.bytesOut)))
+	config.Meter.Histogram(metricConnDuration, labels...).Update(time.Since(c.started).Seconds())
+}
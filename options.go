@@ -3,6 +3,8 @@ package tcp
 import (
 	"crypto/tls"
 	"net"
+	"os"
+	"time"
 
 	"go.unistack.org/micro/v3/server"
 )
@@ -11,11 +13,47 @@ import (
 // or receive.  Default value is 8K
 var DefaultMaxMsgSize = 1024 * 8
 
+// DefaultGracefulTimeout define how long server waits for active connections
+// to be closed on Stop before closing them forcibly
+var DefaultGracefulTimeout = 5 * time.Second
+
 type (
-	maxMsgSizeKey struct{}
-	tlsAuth       struct{}
-	maxConnKey    struct{}
-	netListener   struct{}
+	maxMsgSizeKey      struct{}
+	tlsAuth            struct{}
+	maxConnKey         struct{}
+	netListener        struct{}
+	framerKey          struct{}
+	gracefulTimeoutKey struct{}
+	connWrappersKey    struct{}
+	panicHookKey       struct{}
+	readTimeoutKey     struct{}
+	writeTimeoutKey    struct{}
+	idleTimeoutKey     struct{}
+	keepAliveKey       struct{}
+	noDelayKey         struct{}
+	reusePortKey       struct{}
+	readBufferKey      struct{}
+	writeBufferKey     struct{}
+	lingerKey          struct{}
+	authorizeKey       struct{}
+	certSourceKey      struct{}
+	certReloadKey      struct{}
+	proxyProtocolKey   struct{}
+	socketModeKey      struct{}
+	socketOwnerKey     struct{}
+	listenersKey       struct{}
+	packetWorkersKey   struct{}
+	netPacketConn      struct{}
+	maxConnPerIPKey    struct{}
+	maxConnPerCIDRKey  struct{}
+	acceptRateKey      struct{}
+	allowCIDRKey       struct{}
+	denyCIDRKey        struct{}
+	admitHookKey       struct{}
+	accessLogKey       struct{}
+	workerPoolKey      struct{}
+	eventLoopKey       struct{}
+	restartSignalKey   struct{}
 )
 
 //
@@ -26,7 +64,9 @@ func MaxMsgSize(s int) server.Option {
 	return server.SetOption(maxMsgSizeKey{}, s)
 }
 
-// AuthTLS should be used to setup a secure authentication using TLS
+// AuthTLS should be used to setup a secure authentication using TLS,
+// client certificates required and verified against t.ClientCAs, server
+// certificates taken from TLSConfig if t has none
 func AuthTLS(t *tls.Config) server.Option {
 	return server.SetOption(tlsAuth{}, t)
 }
@@ -36,7 +76,231 @@ func MaxConn(n int) server.Option {
 	return server.SetOption(maxConnKey{}, n)
 }
 
+// MaxConnPerIP limits the number of concurrent connections from single
+// remote ip, can be changed at runtime via Init
+func MaxConnPerIP(n int) server.Option {
+	return server.SetOption(maxConnPerIPKey{}, n)
+}
+
+// MaxConnPerCIDR limits the number of concurrent connections from all
+// addresses of the network, may be specified multiple times,
+// can be changed at runtime via Init, zero n removes the limit
+func MaxConnPerCIDR(cidr string, n int) server.Option {
+	return func(o *server.Options) {
+		var old []cidrLimit
+		if o.Context != nil {
+			old, _ = o.Context.Value(maxConnPerCIDRKey{}).([]cidrLimit)
+		}
+		limits := make([]cidrLimit, 0, len(old)+1)
+		for _, cl := range old {
+			if cl.cidr != cidr {
+				limits = append(limits, cl)
+			}
+		}
+		if n > 0 {
+			limits = append(limits, cidrLimit{cidr: cidr, max: n})
+		}
+		server.SetOption(maxConnPerCIDRKey{}, limits)(o)
+	}
+}
+
+// AcceptRate limits rate of accepted connections per second with token
+// bucket of burst size, can be changed at runtime via Init
+func AcceptRate(rate float64, burst int) server.Option {
+	return server.SetOption(acceptRateKey{}, acceptRate{rate: rate, burst: burst})
+}
+
 // Listener specifies the net.Listener to use instead of the default
 func Listener(l net.Listener) server.Option {
 	return server.SetOption(netListener{}, l)
 }
+
+// Framer specifies the Framing used to split connection stream into frames,
+// handler receives FrameConn that enforces MaxMsgSize on every frame
+func Framer(f Framing) server.Option {
+	return server.SetOption(framerKey{}, f)
+}
+
+// GracefulTimeout specifies how long Stop waits for active connections to be
+// closed by handlers, after timeout remaining connections closed forcibly
+func GracefulTimeout(d time.Duration) server.Option {
+	return server.SetOption(gracefulTimeoutKey{}, d)
+}
+
+// WrapConn adds connection handler wrappers, first wrapper is the outermost
+func WrapConn(w ...ConnWrapper) server.Option {
+	return func(o *server.Options) {
+		var wrappers []ConnWrapper
+		if o.Context != nil {
+			wrappers, _ = o.Context.Value(connWrappersKey{}).([]ConnWrapper)
+		}
+		wrappers = append(wrappers[:len(wrappers):len(wrappers)], w...)
+		server.SetOption(connWrappersKey{}, wrappers)(o)
+	}
+}
+
+// PanicHook specifies func called after panic in connection handler or
+// subscriber recovered and logged
+func PanicHook(fn PanicFunc) server.Option {
+	return server.SetOption(panicHookKey{}, fn)
+}
+
+// ReadTimeout specifies timeout for each connection read, deadline
+// extended before every read
+func ReadTimeout(d time.Duration) server.Option {
+	return server.SetOption(readTimeoutKey{}, d)
+}
+
+// WriteTimeout specifies timeout for each connection write, deadline
+// extended before every write
+func WriteTimeout(d time.Duration) server.Option {
+	return server.SetOption(writeTimeoutKey{}, d)
+}
+
+// IdleTimeout specifies how long connection may have no read or write
+// activity before server closes it
+func IdleTimeout(d time.Duration) server.Option {
+	return server.SetOption(idleTimeoutKey{}, d)
+}
+
+// KeepAlive specifies keep-alive period for accepted connections,
+// if zero default period used, negative value disables keep-alive
+func KeepAlive(d time.Duration) server.Option {
+	return server.SetOption(keepAliveKey{}, d)
+}
+
+// NoDelay controls TCP_NODELAY on accepted connections, by default Nagle's
+// algorithm disabled
+func NoDelay(b bool) server.Option {
+	return server.SetOption(noDelayKey{}, b)
+}
+
+// ReusePort enables SO_REUSEPORT on the listener socket, so multiple
+// processes can bind the same address
+func ReusePort(b bool) server.Option {
+	return server.SetOption(reusePortKey{}, b)
+}
+
+// ReadBuffer specifies socket receive buffer size
+func ReadBuffer(n int) server.Option {
+	return server.SetOption(readBufferKey{}, n)
+}
+
+// WriteBuffer specifies socket send buffer size
+func WriteBuffer(n int) server.Option {
+	return server.SetOption(writeBufferKey{}, n)
+}
+
+// Linger specifies SO_LINGER behaviour for accepted connections,
+// see net.TCPConn.SetLinger
+func Linger(sec int) server.Option {
+	return server.SetOption(lingerKey{}, sec)
+}
+
+// AuthorizePeer specifies func called for each tls connection after
+// handshake to authorize client identity
+func AuthorizePeer(fn AuthorizeFunc) server.Option {
+	return server.SetOption(authorizeKey{}, fn)
+}
+
+// TLSCertSource specifies source of server certificates, it replaces
+// certificates from TLSConfig and enables tls if TLSConfig not set
+func TLSCertSource(src CertSource) server.Option {
+	return server.SetOption(certSourceKey{}, src)
+}
+
+// CertReloadInterval specifies how often CertSource that implements
+// CertReloader reloaded
+func CertReloadInterval(d time.Duration) server.Option {
+	return server.SetOption(certReloadKey{}, d)
+}
+
+// ProxyProtocol enables PROXY protocol v1 and v2 header parsing on accepted
+// connections, connections from addresses outside of trusted cidrs rejected,
+// if no cidrs specified all upstreams trusted, access lists and connection
+// limits applied to client address from the header
+func ProxyProtocol(trusted ...string) server.Option {
+	return server.SetOption(proxyProtocolKey{}, trusted)
+}
+
+// SocketMode specifies file mode of unix domain socket
+func SocketMode(mode os.FileMode) server.Option {
+	return server.SetOption(socketModeKey{}, mode)
+}
+
+// SocketOwner specifies owner uid and gid of unix domain socket
+func SocketOwner(uid, gid int) server.Option {
+	return server.SetOption(socketOwnerKey{}, [2]int{uid, gid})
+}
+
+// AddListener adds listener served in addition to the server Address,
+// may be specified multiple times
+func AddListener(l ListenerConfig) server.Option {
+	return func(o *server.Options) {
+		var listeners []ListenerConfig
+		if o.Context != nil {
+			listeners, _ = o.Context.Value(listenersKey{}).([]ListenerConfig)
+		}
+		listeners = append(listeners[:len(listeners):len(listeners)], l)
+		server.SetOption(listenersKey{}, listeners)(o)
+	}
+}
+
+// PacketConn specifies the net.PacketConn used in packet mode instead of
+// listening on the server Address
+func PacketConn(pc net.PacketConn) server.Option {
+	return server.SetOption(netPacketConn{}, pc)
+}
+
+// PacketWorkers specifies number of goroutines serving datagrams in packet
+// mode, default is runtime.NumCPU
+func PacketWorkers(n int) server.Option {
+	return server.SetOption(packetWorkersKey{}, n)
+}
+
+// AllowCIDR specifies networks or addresses allowed to connect, if set all
+// others denied, can be changed at runtime via Init
+func AllowCIDR(cidrs ...string) server.Option {
+	return server.SetOption(allowCIDRKey{}, cidrs)
+}
+
+// DenyCIDR specifies networks or addresses denied to connect, deny list
+// checked before allow list, can be changed at runtime via Init
+func DenyCIDR(cidrs ...string) server.Option {
+	return server.SetOption(denyCIDRKey{}, cidrs)
+}
+
+// AdmitHook specifies func called for each accepted connection or received
+// datagram allowed by allow and deny lists
+func AdmitHook(fn AdmitFunc) server.Option {
+	return server.SetOption(admitHookKey{}, fn)
+}
+
+// AccessLog enables access log record with connection details written
+// on info level when each connection closed
+func AccessLog(b bool) server.Option {
+	return server.SetOption(accessLogKey{}, b)
+}
+
+// WorkerPool serves connections by fixed number of workers instead of
+// goroutine per connection, accepted connections wait in queue of size
+// and policy specifies what happens when queue is full, queued connections
+// not listed by Conns and closed without draining when server stops
+func WorkerPool(workers int, size int, policy PoolPolicy) server.Option {
+	return server.SetOption(workerPoolKey{}, poolOptions{workers: workers, size: size, policy: policy})
+}
+
+// EventLoop serves connections of EventHandler by event loop with specified
+// number of workers instead of goroutine per connection, if workers is zero
+// runtime.NumCPU used, supported only on linux and can't be combined with
+// MaxConn, tls, framing and proxy protocol
+func EventLoop(workers int) server.Option {
+	return server.SetOption(eventLoopKey{}, workers)
+}
+
+// RestartSignal enables graceful restart on signal, the binary started again
+// with inherited listeners and after new process ready current one stops
+// accepting and receives SIGTERM to shut down, supported only on unix
+func RestartSignal(sig os.Signal) server.Option {
+	return server.SetOption(restartSignalKey{}, sig)
+}
@@ -0,0 +1,236 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// DefaultPacketQueueSize define how many received datagrams wait for a free
+// worker before server stops reading from the socket
+var DefaultPacketQueueSize = 1024
+
+// PacketHandler serves datagrams when server runs in packet mode,
+// b is valid only until ServePacket returns
+type PacketHandler interface {
+	ServePacket(ctx context.Context, addr net.Addr, b []byte)
+}
+
+// PacketHandlerFunc is an adapter to allow the use of ordinary functions as PacketHandler
+type PacketHandlerFunc func(ctx context.Context, addr net.Addr, b []byte)
+
+// ServePacket calls f(ctx, addr, b)
+func (f PacketHandlerFunc) ServePacket(ctx context.Context, addr net.Addr, b []byte) {
+	f(ctx, addr, b)
+}
+
+type packetConnKey struct{}
+
+// PacketConnFromContext returns net.PacketConn from PacketHandler context,
+// it used to send replies
+func PacketConnFromContext(ctx context.Context) (net.PacketConn, bool) {
+	pc, ok := ctx.Value(packetConnKey{}).(net.PacketConn)
+	return pc, ok
+}
+
+type packet struct {
+	addr net.Addr
+	buf  []byte
+	n    int
+}
+
+func (h *tcpServer) getPacketConn() net.PacketConn {
+	if h.opts.Context == nil {
+		return nil
+	}
+	pc, _ := h.opts.Context.Value(netPacketConn{}).(net.PacketConn)
+	return pc
+}
+
+func (h *tcpServer) getPacketWorkers() int {
+	if h.opts.Context != nil {
+		if n, ok := h.opts.Context.Value(packetWorkersKey{}).(int); ok && n > 0 {
+			return n
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// listenPacket creates udp or unixgram socket, unix:// addresses used
+// for unix datagram sockets
+func (h *tcpServer) listenPacket(ctx context.Context, address string, so sockOptions) (net.PacketConn, error) {
+	network, address := parseAddress(address)
+	if network == "unix" {
+		network = "unixgram"
+		if !strings.HasPrefix(address, "@") {
+			if err := removeStaleSocket(address); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		network = "udp"
+	}
+
+	lc := &net.ListenConfig{Control: so.control}
+	pc, err := lc.ListenPacket(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if so.readBuffer > 0 || so.writeBuffer > 0 {
+		if bc, ok := pc.(interface {
+			SetReadBuffer(int) error
+			SetWriteBuffer(int) error
+		}); ok {
+			if so.readBuffer > 0 {
+				err = bc.SetReadBuffer(so.readBuffer)
+			}
+			if err == nil && so.writeBuffer > 0 {
+				err = bc.SetWriteBuffer(so.writeBuffer)
+			}
+			if err != nil {
+				_ = pc.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return pc, nil
+}
+
+// packetAddress returns packet conn address in form accepted by parseAddress
+func packetAddress(pc net.PacketConn) string {
+	addr := pc.LocalAddr()
+	if addr.Network() == "unixgram" {
+		return unixScheme + addr.String()
+	}
+	return addr.String()
+}
+
+// servePacket reads datagrams and dispatch them to the worker pool,
+// it returns after socket closed and all queued datagrams served
+func (h *tcpServer) servePacket(ctx context.Context, pc net.PacketConn, hd PacketHandler, workers int, maxSize int) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+
+	ctx = context.WithValue(ctx, packetConnKey{}, pc)
+
+	// one extra byte used to detect datagrams larger then maxSize
+	pool := sync.Pool{New: func() interface{} { return make([]byte, maxSize+1) }}
+	queue := make(chan packet, DefaultPacketQueueSize)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				h.handlePacket(ctx, hd, p)
+				pool.Put(p.buf) // nolint: staticcheck
+			}
+		}()
+	}
+
+	defer func() {
+		close(queue)
+		wg.Wait()
+	}()
+
+	var tempDelay time.Duration // how long to sleep on read failure
+	for {
+		buf := pool.Get().([]byte)
+		n, addr, err := pc.ReadFrom(buf)
+		// nolint: nestif
+		if err != nil {
+			pool.Put(buf) // nolint: staticcheck
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			// closed on restart while server still running
+			h.RLock()
+			closed := h.packetConn != pc
+			h.RUnlock()
+			if closed {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				if config.Logger.V(logger.ErrorLevel) {
+					config.Logger.Errorf(config.Context, "tcp: ReadFrom error: %v; retrying in %v", err, tempDelay)
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			if config.Logger.V(logger.ErrorLevel) {
+				config.Logger.Errorf(config.Context, "tcp: ReadFrom error: %v", err)
+			}
+			return
+		}
+		tempDelay = 0
+
+		if n > maxSize {
+			pool.Put(buf) // nolint: staticcheck
+			if config.Logger.V(logger.ErrorLevel) {
+				config.Logger.Errorf(config.Context, "tcp: datagram from %s dropped: %v", addr, ErrFrameTooLarge)
+			}
+			continue
+		}
+
+		config.Meter.Counter(metricPackets, metricLabels(config)...).Inc()
+		config.Meter.Counter(metricBytesRead, metricLabels(config)...).Add(n)
+
+		if !h.admit(ctx, addr) {
+			pool.Put(buf) // nolint: staticcheck
+			continue
+		}
+
+		queue <- packet{addr: addr, buf: buf, n: n}
+	}
+}
+
+// drainPackets waits for queued datagrams to be served, after timeout
+// Stop returns while workers finish the queue
+func (h *tcpServer) drainPackets(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		h.packetWg.Wait()
+		close(done)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		h.RLock()
+		config := h.opts
+		h.RUnlock()
+		if config.Logger.V(logger.ErrorLevel) {
+			config.Logger.Errorf(config.Context, "tcp: graceful timeout %v exceeded, queued datagrams still served in background", timeout)
+		}
+	}
+}
+
+func (h *tcpServer) handlePacket(ctx context.Context, hd PacketHandler, p packet) {
+	defer func() {
+		if v := recover(); v != nil {
+			h.recoverPanic(ctx, "packet", v)
+		}
+	}()
+	hd.ServePacket(ctx, p.addr, p.buf[:p.n])
+}
@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.unistack.org/micro/v3/logger"
+	"go.unistack.org/micro/v3/server"
+)
+
+// ErrPoolFull returned when connection rejected or shed by worker pool
+var ErrPoolFull = errors.New("tcp: worker pool queue full")
+
+// PoolPolicy specifies what happens with accepted connection when worker
+// pool queue is full
+type PoolPolicy int
+
+const (
+	// PoolBlock stops accepting connections until queue has free space
+	PoolBlock PoolPolicy = iota
+	// PoolReject closes new connection
+	PoolReject
+	// PoolShedOldest closes the oldest queued connection and queues new one
+	PoolShedOldest
+)
+
+func (p PoolPolicy) String() string {
+	switch p {
+	case PoolBlock:
+		return "block"
+	case PoolReject:
+		return "reject"
+	case PoolShedOldest:
+		return "shed_oldest"
+	}
+	return "unknown"
+}
+
+type poolOptions struct {
+	workers int
+	size    int
+	policy  PoolPolicy
+}
+
+type poolTask struct {
+	c   net.Conn
+	run func()
+}
+
+// workerPool serves accepted connections by fixed number of goroutines,
+// queued connections not tracked until worker serves them, so they not
+// listed by Conns and closed without waiting when server stops
+type workerPool struct {
+	queue   chan poolTask
+	reject  func(c net.Conn, reason string)
+	workers int
+	policy  PoolPolicy
+}
+
+func newWorkerPool(o poolOptions, reject func(net.Conn, string)) *workerPool {
+	if o.size < 1 {
+		o.size = o.workers
+	}
+	return &workerPool{
+		queue:   make(chan poolTask, o.size),
+		reject:  reject,
+		workers: o.workers,
+		policy:  o.policy,
+	}
+}
+
+// start runs workers until ctx done, connections left in queue closed
+func (p *workerPool) start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					p.drain()
+					return
+				case t := <-p.queue:
+					t.run()
+				}
+			}
+		}()
+	}
+}
+
+func (p *workerPool) drain() {
+	for {
+		select {
+		case t := <-p.queue:
+			_ = t.c.Close()
+		default:
+			return
+		}
+	}
+}
+
+// submit queues connection according to pool policy
+func (p *workerPool) submit(ctx context.Context, t poolTask) {
+	switch p.policy {
+	case PoolReject:
+		select {
+		case p.queue <- t:
+		default:
+			p.reject(t.c, "pool_full")
+		}
+	case PoolShedOldest:
+		for {
+			select {
+			case p.queue <- t:
+				return
+			default:
+			}
+			select {
+			case old := <-p.queue:
+				p.reject(old.c, "pool_shed")
+			default:
+			}
+		}
+	default:
+		select {
+		case p.queue <- t:
+		case <-ctx.Done():
+			_ = t.c.Close()
+		}
+	}
+}
+
+func (h *tcpServer) getPoolOptions() (poolOptions, bool) {
+	if h.opts.Context == nil {
+		return poolOptions{}, false
+	}
+	o, ok := h.opts.Context.Value(workerPoolKey{}).(poolOptions)
+	return o, ok && o.workers > 0
+}
+
+// newWorkerPool creates worker pool if WorkerPool option set and registers
+// queue depth gauge
+func (h *tcpServer) newWorkerPool(config server.Options) *workerPool {
+	h.RLock()
+	o, ok := h.getPoolOptions()
+	h.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	p := newWorkerPool(o, func(c net.Conn, reason string) {
+		config.Meter.Counter(metricConnRejected, metricLabels(config, "reason", reason)...).Inc()
+		if config.Logger.V(logger.DebugLevel) {
+			config.Logger.Debugf(config.Context, "tcp: connection from %s rejected: %v", c.RemoteAddr(), ErrPoolFull)
+		}
+		_ = c.Close()
+	})
+	config.Meter.Gauge(metricPoolQueue, func() float64 {
+		return float64(len(p.queue))
+	}, metricLabels(config)...)
+
+	return p
+}
@@ -0,0 +1,275 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrProxyHeader returned when connection has no valid proxy protocol header
+	ErrProxyHeader = errors.New("tcp: invalid proxy protocol header")
+	// ErrUntrustedProxy returned when connection with proxy protocol comes from untrusted address
+	ErrUntrustedProxy = errors.New("tcp: untrusted proxy address")
+
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	proxyV1MaxSize = 107
+)
+
+// ProxyCommand is the proxy protocol v2 command
+type ProxyCommand byte
+
+const (
+	// ProxyLocal connection established by proxy itself, original addresses kept
+	ProxyLocal ProxyCommand = 0x0
+	// ProxyProxy connection relayed on behalf of another node
+	ProxyProxy ProxyCommand = 0x1
+)
+
+// ProxyTLV is the type-length-value field from proxy protocol v2 header
+type ProxyTLV struct {
+	Value []byte
+	Type  byte
+}
+
+// ProxyHeader contains parsed proxy protocol header
+type ProxyHeader struct {
+	// SourceAddr is the real client address
+	SourceAddr net.Addr
+	// DestinationAddr is the address client connected to
+	DestinationAddr net.Addr
+	// TLVs contains v2 type-length-value fields
+	TLVs    []ProxyTLV
+	Version int
+	Command ProxyCommand
+}
+
+// TLV returns value of first tlv with specified type
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+type proxyHeaderKey struct{}
+
+// ProxyHeaderFromContext returns proxy protocol header from handler context
+func ProxyHeaderFromContext(ctx context.Context) (*ProxyHeader, bool) {
+	h, ok := ctx.Value(proxyHeaderKey{}).(*ProxyHeader)
+	return h, ok
+}
+
+// proxyProtocol parses proxy protocol header from trusted upstreams
+type proxyProtocol struct {
+	trusted []*net.IPNet
+}
+
+func newProxyProtocol(cidrs []string) (*proxyProtocol, error) {
+	p := &proxyProtocol{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("tcp: invalid proxy protocol trusted cidr %q: %v", cidr, err)
+		}
+		p.trusted = append(p.trusted, ipnet)
+	}
+	return p, nil
+}
+
+func (p *proxyProtocol) isTrusted(addr net.Addr) bool {
+	if len(p.trusted) == 0 {
+		return true
+	}
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range p.trusted {
+		if ipnet.Contains(ta.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept reads proxy protocol header and returns conn with real addresses
+func (p *proxyProtocol) accept(c net.Conn) (*proxyConn, error) {
+	if !p.isTrusted(c.RemoteAddr()) {
+		return nil, ErrUntrustedProxy
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(DefaultHandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(c)
+	hdr, err := readProxyHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{Conn: c, br: br, hdr: hdr}, nil
+}
+
+func readProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	if b, err := br.Peek(len(proxyV1Prefix)); err == nil && bytes.Equal(b, proxyV1Prefix) {
+		return readProxyHeaderV1(br)
+	}
+	if b, err := br.Peek(len(proxyV2Sig)); err == nil && bytes.Equal(b, proxyV2Sig) {
+		return readProxyHeaderV2(br)
+	}
+	return nil, ErrProxyHeader
+}
+
+func readProxyHeaderV1(br *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxSize {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+
+	hdr := &ProxyHeader{Version: 1, Command: ProxyProxy}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		hdr.Command = ProxyLocal
+		return hdr, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeader
+	}
+
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	hdr.SourceAddr, hdr.DestinationAddr = src, dst
+
+	return hdr, nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, ErrProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyHeaderV2(br *bufio.Reader) (*ProxyHeader, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, err
+	}
+	if buf[12]>>4 != 0x2 {
+		return nil, ErrProxyHeader
+	}
+
+	hdr := &ProxyHeader{Version: 2, Command: ProxyCommand(buf[12] & 0x0f)}
+	if hdr.Command != ProxyLocal && hdr.Command != ProxyProxy {
+		return nil, ErrProxyHeader
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+
+	var alen int
+	switch buf[13] >> 4 {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		alen = 12
+	case 0x2: // AF_INET6
+		alen = 36
+	case 0x3: // AF_UNIX
+		alen = 216
+	default:
+		return nil, ErrProxyHeader
+	}
+	if len(payload) < alen {
+		return nil, ErrProxyHeader
+	}
+
+	// only stream transport addresses are meaningful for tcp server
+	if hdr.Command == ProxyProxy && buf[13]&0x0f == 0x1 {
+		switch alen {
+		case 12:
+			hdr.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+			hdr.DestinationAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		case 36:
+			hdr.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+			hdr.DestinationAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		}
+	}
+
+	tlvs := payload[alen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, ErrProxyHeader
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, ErrProxyHeader
+		}
+		hdr.TLVs = append(hdr.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+
+	return hdr, nil
+}
+
+// proxyConn reports addresses from proxy protocol header
+type proxyConn struct {
+	net.Conn
+	br  *bufio.Reader
+	hdr *ProxyHeader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.hdr.SourceAddr != nil {
+		return c.hdr.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.hdr.DestinationAddr != nil {
+		return c.hdr.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
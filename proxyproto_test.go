@@ -0,0 +1,230 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// proxyV2Header returns v2 header with version and command byte ver,
+// family and transport byte fam and payload
+func proxyV2Header(ver, fam byte, payload []byte) []byte {
+	b := append([]byte{}, proxyV2Sig...)
+	b = append(b, ver, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	inet := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x04, 0x57, 0x08, 0xae}
+	inet6 := make([]byte, 36)
+	inet6[15], inet6[31] = 1, 2
+	binary.BigEndian.PutUint16(inet6[32:], 1111)
+	binary.BigEndian.PutUint16(inet6[34:], 2222)
+	unix := make([]byte, 216)
+	copy(unix, "/run/src.sock")
+	copy(unix[108:], "/run/dst.sock")
+
+	tests := []struct {
+		name string
+		in   []byte
+		hdr  *ProxyHeader
+		err  error
+	}{
+		{
+			name: "v1 tcp4",
+			in:   []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\n"),
+			hdr: &ProxyHeader{
+				Version:         1,
+				Command:         ProxyProxy,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1111},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 2222},
+			},
+		},
+		{
+			name: "v1 tcp6",
+			in:   []byte("PROXY TCP6 ::1 ::2 1111 2222\r\n"),
+			hdr: &ProxyHeader{
+				Version:         1,
+				Command:         ProxyProxy,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1111},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("::2"), Port: 2222},
+			},
+		},
+		{
+			name: "v1 unknown",
+			in:   []byte("PROXY UNKNOWN\r\n"),
+			hdr:  &ProxyHeader{Version: 1, Command: ProxyLocal},
+		},
+		{
+			name: "v1 truncated",
+			in:   []byte("PROXY TCP4 1.2.3.4 5.6"),
+			err:  io.EOF,
+		},
+		{
+			name: "v1 without crlf",
+			in:   []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 too long",
+			in:   append(append([]byte("PROXY "), bytes.Repeat([]byte("x"), 200)...), "\r\n"...),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 bad port",
+			in:   []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 70000\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 bad address",
+			in:   []byte("PROXY TCP4 1.2.3 5.6.7.8 1111 2222\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 bad protocol",
+			in:   []byte("PROXY UDP4 1.2.3.4 5.6.7.8 1111 2222\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 inet",
+			in:   proxyV2Header(0x21, 0x11, inet),
+			hdr: &ProxyHeader{
+				Version:         2,
+				Command:         ProxyProxy,
+				SourceAddr:      &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1111},
+				DestinationAddr: &net.TCPAddr{IP: net.IP{5, 6, 7, 8}, Port: 2222},
+			},
+		},
+		{
+			name: "v2 inet6",
+			in:   proxyV2Header(0x21, 0x21, inet6),
+			hdr: &ProxyHeader{
+				Version:         2,
+				Command:         ProxyProxy,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1111},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("::2"), Port: 2222},
+			},
+		},
+		{
+			name: "v2 unix",
+			in:   proxyV2Header(0x21, 0x31, unix),
+			hdr:  &ProxyHeader{Version: 2, Command: ProxyProxy},
+		},
+		{
+			name: "v2 udp",
+			in:   proxyV2Header(0x21, 0x12, inet),
+			hdr:  &ProxyHeader{Version: 2, Command: ProxyProxy},
+		},
+		{
+			name: "v2 local",
+			in:   proxyV2Header(0x20, 0x00, nil),
+			hdr:  &ProxyHeader{Version: 2, Command: ProxyLocal},
+		},
+		{
+			name: "v2 local with addresses",
+			in:   proxyV2Header(0x20, 0x11, inet),
+			hdr:  &ProxyHeader{Version: 2, Command: ProxyLocal},
+		},
+		{
+			name: "v2 tlvs",
+			in:   proxyV2Header(0x21, 0x11, append(append([]byte{}, inet...), 0x04, 0, 2, 'i', 'd', 0x01, 0, 0)),
+			hdr: &ProxyHeader{
+				Version:         2,
+				Command:         ProxyProxy,
+				SourceAddr:      &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1111},
+				DestinationAddr: &net.TCPAddr{IP: net.IP{5, 6, 7, 8}, Port: 2222},
+				TLVs:            []ProxyTLV{{Type: 0x04, Value: []byte("id")}, {Type: 0x01, Value: []byte{}}},
+			},
+		},
+		{
+			name: "v2 tlv length exceeds payload",
+			in:   proxyV2Header(0x21, 0x11, append(append([]byte{}, inet...), 0x04, 0, 3, 'i', 'd')),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 tlv without length",
+			in:   proxyV2Header(0x21, 0x11, append(append([]byte{}, inet...), 0x04, 0)),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 short address",
+			in:   proxyV2Header(0x21, 0x11, inet[:8]),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 short unix address",
+			in:   proxyV2Header(0x21, 0x31, unix[:108]),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 bad version",
+			in:   proxyV2Header(0x11, 0x11, inet),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 bad command",
+			in:   proxyV2Header(0x22, 0x11, inet),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 bad family",
+			in:   proxyV2Header(0x21, 0x41, inet),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 truncated header",
+			in:   proxyV2Header(0x21, 0x11, inet)[:14],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v2 truncated payload",
+			in:   proxyV2Header(0x21, 0x11, inet)[:20],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v2 truncated signature",
+			in:   proxyV2Sig[:8],
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "no header",
+			in:   []byte("GET / HTTP/1.1\r\n\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "empty",
+			err:  ErrProxyHeader,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(tt.in)))
+			if err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(hdr, tt.hdr) {
+				t.Fatalf("header %+v, want %+v", hdr, tt.hdr)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderKeepsData(t *testing.T) {
+	br := bufio.NewReader(bytes.NewReader([]byte("PROXY UNKNOWN\r\nhello")))
+	if _, err := readProxyHeader(br); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("data %q, want %q", b, "hello")
+	}
+}
@@ -0,0 +1,46 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// PanicFunc called when panic recovered in connection handler or subscriber,
+// ctx is the connection or subscriber context
+type PanicFunc func(ctx context.Context, v interface{}, stack []byte)
+
+func (h *tcpServer) getPanicHook() PanicFunc {
+	h.RLock()
+	defer h.RUnlock()
+	if h.opts.Context == nil {
+		return nil
+	}
+	fn, _ := h.opts.Context.Value(panicHookKey{}).(PanicFunc)
+	return fn
+}
+
+// recoverPanic logs recovered panic with stack trace, counts it and
+// calls custom panic hook
+func (h *tcpServer) recoverPanic(ctx context.Context, kind string, v interface{}) {
+	stack := debug.Stack()
+
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+
+	if config.Logger.V(logger.ErrorLevel) {
+		config.Logger.Errorf(ctx, "tcp: panic recovered in %s: %v\n%s", kind, v, stack)
+	}
+	config.Meter.Counter(metricPanics, metricLabels(config, "kind", kind)...).Inc()
+
+	if fn := h.getPanicHook(); fn != nil {
+		fn(ctx, v, stack)
+	}
+}
+
+func panicError(v interface{}) error {
+	return fmt.Errorf("panic recovered: %v", v)
+}
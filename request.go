@@ -12,6 +12,7 @@ type tcpRequest struct {
 	codec       codec.Codec
 	body        interface{}
 	header      map[string]string
+	raw         []byte
 	method      string
 	endpoint    string
 	contentType string
@@ -43,7 +44,7 @@ func (r *tcpRequest) Body() interface{} {
 }
 
 func (r *tcpRequest) Read() ([]byte, error) {
-	return nil, nil
+	return r.raw, nil
 }
 
 func (r *tcpRequest) Stream() bool {
@@ -0,0 +1,69 @@
+package tcp
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// restartFDNamesEnv contains comma separated names of inherited listeners
+	restartFDNamesEnv = "MICRO_TCP_FDNAMES"
+	// restartReadyFDEnv contains pipe fd closed by new process when ready
+	restartReadyFDEnv = "MICRO_TCP_READY_FD"
+)
+
+// DefaultRestartTimeout define how long server waits for restarted process
+// to become ready before it killed
+var DefaultRestartTimeout = 30 * time.Second
+
+func (h *tcpServer) getRestartSignal() os.Signal {
+	if h.opts.Context == nil {
+		return nil
+	}
+	sig, _ := h.opts.Context.Value(restartSignalKey{}).(os.Signal)
+	return sig
+}
+
+// restartFiles returns listeners files and ready pipe passed by parent
+// process, env cleared so files not passed further
+func restartFiles() (map[string]*os.File, *os.File) {
+	env := os.Getenv(restartFDNamesEnv)
+	if env == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(restartFDNamesEnv)
+
+	parts := strings.Split(env, ",")
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, err := url.QueryUnescape(part)
+		if err != nil {
+			name = part
+		}
+		names = append(names, name)
+	}
+
+	var ready *os.File
+	if fd, err := strconv.Atoi(os.Getenv(restartReadyFDEnv)); err == nil && fd >= inheritedFDStart {
+		ready = os.NewFile(uintptr(fd), "ready")
+	}
+	_ = os.Unsetenv(restartReadyFDEnv)
+
+	return inheritFiles(names), ready
+}
+
+// notifyRestartReady notifies parent process that listeners ready
+func (h *tcpServer) notifyRestartReady() {
+	h.Lock()
+	ready := h.restartReady
+	h.restartReady = nil
+	h.Unlock()
+	if ready == nil {
+		return
+	}
+	_, _ = ready.Write([]byte{1})
+	_ = ready.Close()
+}
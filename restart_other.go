@@ -0,0 +1,20 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package tcp
+
+import (
+	"context"
+	"os"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+func (h *tcpServer) watchRestart(ctx context.Context, sig os.Signal) {
+	h.RLock()
+	config := h.opts
+	h.RUnlock()
+	if config.Logger.V(logger.ErrorLevel) {
+		config.Logger.Errorf(config.Context, "tcp: graceful restart not supported on this platform")
+	}
+}
@@ -0,0 +1,180 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.unistack.org/micro/v3/logger"
+)
+
+// watchRestart restarts process on signal until ctx done
+func (h *tcpServer) watchRestart(ctx context.Context, sig os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+
+		h.RLock()
+		config := h.opts
+		h.RUnlock()
+
+		if config.Logger.V(logger.InfoLevel) {
+			config.Logger.Infof(config.Context, "tcp: restarting on signal %v", sig)
+		}
+		pid, err := h.restart(DefaultRestartTimeout)
+		if err != nil {
+			if config.Logger.V(logger.ErrorLevel) {
+				config.Logger.Errorf(config.Context, "tcp: restart failed: %v", err)
+			}
+			continue
+		}
+		if config.Logger.V(logger.InfoLevel) {
+			config.Logger.Infof(config.Context, "tcp: process %d ready, shutting down", pid)
+		}
+
+		// service continues in the new process, so stopped without notifying systemd
+		h.Lock()
+		h.handoff = true
+		h.Unlock()
+
+		// new connections accepted by the new process only
+		_ = h.closeListeners()
+		_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		return
+	}
+}
+
+// restart starts the binary with inherited listeners and packet conn and
+// waits until it ready
+func (h *tcpServer) restart(timeout time.Duration) (int, error) {
+	h.RLock()
+	listeners := h.listeners
+	pc := h.packetConn
+	name := h.opts.Name
+	h.RUnlock()
+
+	var fds []uintptr
+	var names []string
+	defer func() {
+		for _, fd := range fds {
+			_ = syscall.Close(int(fd))
+		}
+	}()
+	for _, l := range listeners {
+		if l.raw == nil {
+			return 0, fmt.Errorf("listener %s not opened by server", l.name)
+		}
+		fd, err := socketFD(unwrapListener(l.raw))
+		if err != nil {
+			return 0, err
+		}
+		fds = append(fds, uintptr(fd))
+		names = append(names, url.QueryEscape(l.name))
+	}
+	if pc != nil {
+		fd, err := socketFD(pc)
+		if err != nil {
+			return 0, err
+		}
+		fds = append(fds, uintptr(fd))
+		names = append(names, url.QueryEscape(name))
+	}
+	if len(fds) == 0 {
+		return 0, fmt.Errorf("no sockets to pass")
+	}
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer rd.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		_ = wr.Close()
+		return 0, err
+	}
+
+	// os/exec switches passed files to blocking mode, that blocks accept
+	// of the listeners shared with the new process, so raw fds used
+	files := append([]uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}, fds...)
+	files = append(files, wr.Fd())
+	// watchdog pid of the current process ignored by the new one
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		restartFDNamesEnv+"="+strings.Join(names, ","),
+		restartReadyFDEnv+"="+strconv.Itoa(inheritedFDStart+len(fds)),
+	)
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{Env: env, Files: files})
+	_ = wr.Close()
+	if err != nil {
+		return 0, err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// new process writes to the pipe when ready, pipe closed if it exits
+	_ = rd.SetReadDeadline(time.Now().Add(timeout))
+	if _, err = io.ReadFull(rd, make([]byte, 1)); err != nil {
+		_ = proc.Kill()
+		_, _ = proc.Wait()
+		return 0, fmt.Errorf("process %d not ready: %v", pid, err)
+	}
+
+	// new process is the main process of the service now
+	h.notifySystemd(sdNotifyMainPID + strconv.Itoa(pid))
+
+	// unix socket file used by the new process
+	for _, l := range listeners {
+		if ul, ok := unwrapListener(l.raw).(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	_ = proc.Release()
+	return pid, nil
+}
+
+// socketFD returns duplicate of listener or packet conn file descriptor
+func socketFD(v interface{}) (int, error) {
+	sc, ok := v.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("socket %T has no file descriptor", v)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd := -1
+	if cerr := rc.Control(func(sfd uintptr) {
+		fd, err = syscall.Dup(int(sfd))
+	}); cerr != nil {
+		return 0, cerr
+	}
+	return fd, err
+}
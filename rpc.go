@@ -0,0 +1,349 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.unistack.org/micro/v3/codec"
+	"go.unistack.org/micro/v3/logger"
+	"go.unistack.org/micro/v3/metadata"
+	"go.unistack.org/micro/v3/register"
+	"go.unistack.org/micro/v3/server"
+	"go.unistack.org/micro/v3/tracer"
+)
+
+const (
+	rpcSig = "func(context.Context, *Request, *Response) error"
+
+	headerContentType = "Content-Type"
+	headerEndpoint    = "Micro-Endpoint"
+	headerService     = "Micro-Service"
+	headerID          = "Micro-Id"
+	headerError       = "Micro-Error"
+)
+
+// DefaultRPCFraming used for rpc connections when Framer option not set
+var DefaultRPCFraming = LengthFraming(PrefixUint32, binary.BigEndian)
+
+var (
+	typeOfContext  = reflect.TypeOf((*context.Context)(nil)).Elem()
+	headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+)
+
+type rpcMethod struct {
+	reqType reflect.Type
+	rspType reflect.Type
+	method  reflect.Method
+}
+
+type rpcService struct {
+	rcvr    reflect.Value
+	methods map[string]*rpcMethod
+	name    string
+}
+
+// newRPCService extracts exported methods with rpcSig signature from handler struct
+func newRPCService(hd interface{}) (*rpcService, error) {
+	if hd == nil {
+		return nil, fmt.Errorf("rpc handler is nil")
+	}
+
+	typ := reflect.TypeOf(hd)
+	rcvr := reflect.ValueOf(hd)
+	name := reflect.Indirect(rcvr).Type().Name()
+	if !isExported(name) {
+		return nil, fmt.Errorf("rpc handler type %s is not exported", name)
+	}
+
+	svc := &rpcService{
+		name:    name,
+		rcvr:    rcvr,
+		methods: make(map[string]*rpcMethod),
+	}
+
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		if err := validateRPCMethod(method); err != nil {
+			return nil, fmt.Errorf("rpc handler %s.%s: %v", name, method.Name, err)
+		}
+		svc.methods[method.Name] = &rpcMethod{
+			method:  method,
+			reqType: method.Type.In(2),
+			rspType: method.Type.In(3),
+		}
+	}
+
+	if len(svc.methods) == 0 {
+		return nil, fmt.Errorf("rpc handler %s has no exported methods of signature %s", name, rpcSig)
+	}
+
+	return svc, nil
+}
+
+// endpoints returns register endpoints for all rpc methods sorted by name,
+// md contains optional endpoint metadata keyed by endpoint name
+func (s *rpcService) endpoints(md map[string]metadata.Metadata) []*register.Endpoint {
+	eps := make([]*register.Endpoint, 0, len(s.methods))
+	for name, m := range s.methods {
+		ep := &register.Endpoint{
+			Name:     s.name + "." + name,
+			Request:  register.ExtractValue(m.reqType, 0),
+			Response: register.ExtractValue(m.rspType, 0),
+			Metadata: metadata.New(0),
+		}
+		if epmd, ok := md[ep.Name]; ok {
+			ep.Metadata = metadata.Copy(epmd)
+		}
+		ep.Metadata.Set("endpoint", ep.Name)
+		eps = append(eps, ep)
+	}
+	sort.Slice(eps, func(i, j int) bool {
+		return eps[i].Name < eps[j].Name
+	})
+	return eps
+}
+
+// method resolves "Service.Method" endpoint to the rpc method
+func (s *rpcService) method(endpoint string) (*rpcMethod, bool) {
+	idx := strings.Index(endpoint, ".")
+	if idx <= 0 || endpoint[:idx] != s.name {
+		return nil, false
+	}
+	m, ok := s.methods[endpoint[idx+1:]]
+	return m, ok
+}
+
+func validateRPCMethod(method reflect.Method) error {
+	mtype := method.Type
+	if mtype.NumIn() != 4 {
+		return fmt.Errorf("wrong number of args: %v required signature %s", mtype.NumIn(), rpcSig)
+	}
+	if ctxType := mtype.In(1); ctxType != typeOfContext {
+		return fmt.Errorf("first argument %v is not context.Context", ctxType)
+	}
+	if reqType := mtype.In(2); reqType.Kind() != reflect.Ptr || !isExportedOrBuiltinType(reqType) {
+		return fmt.Errorf("request type %v is not exported pointer", reqType)
+	}
+	if rspType := mtype.In(3); rspType.Kind() != reflect.Ptr || !isExportedOrBuiltinType(rspType) {
+		return fmt.Errorf("response type %v is not exported pointer", rspType)
+	}
+	if mtype.NumOut() != 1 {
+		return fmt.Errorf("wrong number of outs: %v required signature %s", mtype.NumOut(), rpcSig)
+	}
+	if returnType := mtype.Out(0); returnType != typeOfError {
+		return fmt.Errorf("returns %v not error", returnType.String())
+	}
+	return nil
+}
+
+// rpcHandler reads requests from connection and dispatch them to the rpcService.
+// Each request and response consist of two frames: header and body.
+// Header frame contains "Key: Value" lines, body frame encoded by the codec
+// selected by Content-Type header.
+type rpcHandler struct {
+	s          *tcpServer
+	svc        *rpcService
+	framing    Framing
+	maxMsgSize int
+}
+
+func (r *rpcHandler) ServeConn(ctx context.Context, c net.Conn) {
+	defer c.Close()
+
+	r.s.RLock()
+	config := r.s.opts
+	r.s.RUnlock()
+
+	fc, ok := c.(FrameConn)
+	if !ok {
+		fc = newFrameConn(c, r.framing, r.maxMsgSize)
+	}
+
+	// unblock waiting for next request when server stops,
+	// in-flight request completes and connection closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = fc.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	for ctx.Err() == nil {
+		hb, err := fc.ReadFrame()
+		if err == nil {
+			var bb []byte
+			if bb, err = fc.ReadFrame(); err == nil {
+				err = r.serveRequest(ctx, fc, config, hb, bb)
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil && config.Logger.V(logger.ErrorLevel) {
+				config.Logger.Errorf(config.Context, "tcp: rpc connection %s error: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+func (r *rpcHandler) serveRequest(ctx context.Context, fc FrameConn, config server.Options, hb []byte, bb []byte) error {
+	hdr, err := decodeHeader(hb)
+	if err != nil {
+		return err
+	}
+
+	ct := hdr[headerContentType]
+	cf, err := r.s.newCodec(ct)
+	if err != nil {
+		return r.writeResponse(fc, hdr, nil, nil, fmt.Errorf("invalid content type %q: %v", ct, err))
+	}
+
+	endpoint := hdr[headerEndpoint]
+	m, ok := r.svc.method(endpoint)
+	if !ok {
+		return r.writeResponse(fc, hdr, cf, nil, fmt.Errorf("unknown endpoint %q", endpoint))
+	}
+
+	req := reflect.New(m.reqType.Elem())
+	buf := bytes.NewBuffer(bb)
+	if err = cf.ReadHeader(buf, &codec.Message{Type: codec.Request}, codec.Request); err != nil {
+		return r.writeResponse(fc, hdr, cf, nil, err)
+	}
+	if err = cf.ReadBody(buf, req.Interface()); err != nil {
+		return r.writeResponse(fc, hdr, cf, nil, err)
+	}
+	rsp := reflect.New(m.rspType.Elem())
+
+	md := make(metadata.Metadata, len(hdr))
+	for k, v := range hdr {
+		if k == headerContentType {
+			continue
+		}
+		md[k] = v
+	}
+	// request context carries connection, peer, logger, tracer and meter
+	// and cancelled when server stops
+	ctx = metadata.NewIncomingContext(ctx, md)
+
+	service := hdr[headerService]
+	if service == "" {
+		service = config.Name
+	}
+
+	ctx, sp := config.Tracer.Start(ctx, "Handler "+endpoint,
+		tracer.WithSpanKind(tracer.SpanKindServer),
+		tracer.WithSpanLabels("rpc.service", service, "rpc.method", endpoint),
+	)
+	defer sp.Finish()
+
+	fn := func(ctx context.Context, req server.Request, rsp interface{}) error {
+		returnValues := m.method.Func.Call([]reflect.Value{r.svc.rcvr, reflect.ValueOf(ctx), reflect.ValueOf(req.Body()), reflect.ValueOf(rsp)})
+		if err := returnValues[0].Interface(); err != nil {
+			return err.(error)
+		}
+		return nil
+	}
+
+	for i := len(config.HdlrWrappers); i > 0; i-- {
+		fn = config.HdlrWrappers[i-1](fn)
+	}
+
+	start := time.Now()
+	err = fn(ctx, &tcpRequest{
+		service:     service,
+		method:      endpoint,
+		endpoint:    endpoint,
+		contentType: ct,
+		header:      hdr,
+		body:        req.Interface(),
+		raw:         bb,
+		codec:       cf,
+	}, rsp.Interface())
+
+	if err != nil {
+		sp.SetStatus(tracer.SpanStatusError, err.Error())
+	}
+	config.Meter.Counter(metricRequests, metricLabels(config, "endpoint", endpoint, "status", metricStatus(err))...).Inc()
+	config.Meter.Histogram(metricRequestLatency, metricLabels(config, "endpoint", endpoint)...).UpdateDuration(start)
+
+	return r.writeResponse(fc, hdr, cf, rsp.Interface(), err)
+}
+
+func (r *rpcHandler) writeResponse(fc FrameConn, reqHdr metadata.Metadata, cf codec.Codec, rsp interface{}, rerr error) error {
+	hdr := metadata.New(4)
+	if id, ok := reqHdr[headerID]; ok {
+		hdr[headerID] = id
+	}
+	if endpoint, ok := reqHdr[headerEndpoint]; ok {
+		hdr[headerEndpoint] = endpoint
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if rerr == nil {
+		hdr[headerContentType] = reqHdr[headerContentType]
+		msg := &codec.Message{
+			Type:     codec.Response,
+			Header:   hdr,
+			Endpoint: hdr[headerEndpoint],
+			ID:       hdr[headerID],
+		}
+		if err := cf.Write(buf, msg, rsp); err != nil {
+			rerr = err
+			buf.Reset()
+		}
+	}
+	if rerr != nil {
+		hdr[headerError] = rerr.Error()
+	}
+
+	if err := fc.WriteFrame(encodeHeader(hdr)); err != nil {
+		return err
+	}
+	return fc.WriteFrame(buf.Bytes())
+}
+
+// encodeHeader encodes metadata as sorted "Key: Value" lines
+func encodeHeader(md metadata.Metadata) []byte {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(nil)
+	for _, k := range keys {
+		buf.WriteString(k)
+		buf.WriteString(": ")
+		buf.WriteString(headerReplacer.Replace(md[k]))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// decodeHeader decodes "Key: Value" lines into metadata with canonical keys
+func decodeHeader(b []byte) (metadata.Metadata, error) {
+	md := metadata.New(0)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("tcp: malformed header line %q", line)
+		}
+		md[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:idx]))] = strings.TrimSpace(line[idx+1:])
+	}
+	return md, nil
+}
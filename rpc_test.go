@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	"reflect"
+	"testing"
+
+	"go.unistack.org/micro/v3/metadata"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		md   metadata.Metadata
+		err  bool
+	}{
+		{
+			name: "canonical",
+			in:   "Content-Type: application/json\nMicro-Endpoint: Svc.Method\n",
+			md:   metadata.Metadata{"Content-Type": "application/json", "Micro-Endpoint": "Svc.Method"},
+		},
+		{
+			name: "lower case",
+			in:   "content-type: application/json\r\nmicro-endpoint: Svc.Method\r\nmicro-id:  1 \r\n",
+			md:   metadata.Metadata{"Content-Type": "application/json", "Micro-Endpoint": "Svc.Method", "Micro-Id": "1"},
+		},
+		{
+			name: "empty lines",
+			in:   "\nMicro-Id: 1\n\n",
+			md:   metadata.Metadata{"Micro-Id": "1"},
+		},
+		{
+			name: "value with colon",
+			in:   "X-Addr: 127.0.0.1:8080\n",
+			md:   metadata.Metadata{"X-Addr": "127.0.0.1:8080"},
+		},
+		{
+			name: "missing colon",
+			in:   "Micro-Id 1\n",
+			err:  true,
+		},
+		{
+			name: "empty key",
+			in:   ": 1\n",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := decodeHeader([]byte(tt.in))
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v, want error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(md, tt.md) {
+				t.Fatalf("metadata %v, want %v", md, tt.md)
+			}
+		})
+	}
+}
+
+func TestEncodeHeaderRoundTrip(t *testing.T) {
+	md := metadata.Metadata{"Micro-Id": "1", "Micro-Error": "line\nbreak"}
+	got, err := decodeHeader(encodeHeader(md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := metadata.Metadata{"Micro-Id": "1", "Micro-Error": "line break"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata %v, want %v", got, want)
+	}
+}
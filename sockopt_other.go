@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package tcp
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setSockOptions supports only SO_REUSEPORT on unix platforms, buffer sizes
+// applied to accepted connections
+func setSockOptions(fd uintptr, so sockOptions) error {
+	if so.reusePort {
+		return fmt.Errorf("tcp: SO_REUSEPORT not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// withUmask runs fn, platform has no umask
+func withUmask(mask int, fn func() error) (int, error) {
+	return 0, fn()
+}
@@ -0,0 +1,8 @@
+//go:build (linux && !386 && !amd64 && !arm) || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux,!386,!amd64,!arm darwin dragonfly freebsd netbsd openbsd
+
+package tcp
+
+import "syscall"
+
+const soReusePort = syscall.SO_REUSEPORT
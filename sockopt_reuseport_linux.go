@@ -0,0 +1,8 @@
+//go:build linux && (386 || amd64 || arm)
+// +build linux
+// +build 386 amd64 arm
+
+package tcp
+
+// syscall package lacks SO_REUSEPORT for these architectures
+const soReusePort = 0xf
@@ -0,0 +1,42 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package tcp
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+func setSockOptions(fd uintptr, so sockOptions) error {
+	if so.reusePort {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if so.readBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, so.readBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if so.writeBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, so.writeBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
+
+var umaskMu sync.Mutex
+
+// withUmask runs fn with process umask set to mask and returns previous umask,
+// umask is process wide so other files created meantime affected too
+func withUmask(mask int, fn func() error) (int, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(mask)
+	err := fn()
+	syscall.Umask(old)
+	return old, err
+}
@@ -6,14 +6,18 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
 	"go.unistack.org/micro/v3/broker"
 	"go.unistack.org/micro/v3/codec"
+	"go.unistack.org/micro/v3/logger"
 	"go.unistack.org/micro/v3/metadata"
+	"go.unistack.org/micro/v3/meter"
 	"go.unistack.org/micro/v3/register"
 	"go.unistack.org/micro/v3/server"
+	"go.unistack.org/micro/v3/tracer"
 )
 
 const (
@@ -200,7 +204,12 @@ func (s *tcpServer) createSubHandler(sb *tcpSubscriber, opts server.Options) bro
 			}
 			hdr[k] = v
 		}
-		ctx := metadata.NewIncomingContext(context.Background(), hdr)
+		// message headers passed as incoming metadata to tracer Start,
+		// which continues trace from them, and to handlers
+		ctx := logger.NewContext(context.Background(), opts.Logger)
+		ctx = tracer.NewContext(ctx, opts.Tracer)
+		ctx = meter.NewContext(ctx, opts.Meter)
+		ctx = metadata.NewIncomingContext(ctx, hdr)
 
 		results := make(chan error, len(sb.handlers))
 
@@ -253,13 +262,32 @@ func (s *tcpServer) createSubHandler(sb *tcpSubscriber, opts server.Options) bro
 			}
 
 			go func() {
-				results <- fn(ctx, &tcpMessage{
+				hctx, sp := opts.Tracer.Start(ctx, "Subscriber "+sb.topic,
+					tracer.WithSpanKind(tracer.SpanKindConsumer),
+					tracer.WithSpanLabels("topic", sb.topic),
+				)
+				defer sp.Finish()
+				defer func() {
+					if v := recover(); v != nil {
+						s.recoverPanic(hctx, "subscriber", v)
+						sp.SetStatus(tracer.SpanStatusError, panicError(v).Error())
+						results <- panicError(v)
+					}
+				}()
+				start := time.Now()
+				herr := fn(hctx, &tcpMessage{
 					topic:       sb.topic,
 					contentType: ct,
 					payload:     req.Interface(),
 					header:      msg.Header,
 					codec:       cf,
 				})
+				if herr != nil {
+					sp.SetStatus(tracer.SpanStatusError, herr.Error())
+				}
+				opts.Meter.Counter(metricSubMessages, metricLabels(opts, "topic", sb.topic, "status", metricStatus(herr))...).Inc()
+				opts.Meter.Histogram(metricSubLatency, metricLabels(opts, "topic", sb.topic)...).UpdateDuration(start)
+				results <- herr
 			}()
 		}
 
@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"go.unistack.org/micro/v3/broker"
+	"go.unistack.org/micro/v3/codec"
+	"go.unistack.org/micro/v3/metadata"
+	"go.unistack.org/micro/v3/server"
+	"go.unistack.org/micro/v3/tracer"
+)
+
+// mdTracer records incoming metadata seen by Start, tracer implementations
+// extract parent span context from it
+type mdTracer struct {
+	tracer.Tracer
+	md chan metadata.Metadata
+}
+
+func (t *mdTracer) Start(ctx context.Context, name string, opts ...tracer.SpanOption) (context.Context, tracer.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	t.md <- md
+	return ctx, &testSpan{}
+}
+
+type testSpan struct {
+	tracer.Span
+}
+
+func (s *testSpan) Finish(opts ...tracer.SpanOption)               {}
+func (s *testSpan) SetStatus(status tracer.SpanStatus, msg string) {}
+func (s *testSpan) AddLabels(kv ...interface{})                    {}
+
+type testEvent struct {
+	broker.Event
+	msg *broker.Message
+}
+
+func (e *testEvent) Topic() string {
+	return "topic"
+}
+
+func (e *testEvent) Message() *broker.Message {
+	return e.msg
+}
+
+type testCodec struct {
+	codec.Codec
+}
+
+func (c *testCodec) ReadHeader(r io.Reader, m *codec.Message, t codec.MessageType) error {
+	return nil
+}
+
+func (c *testCodec) ReadBody(r io.Reader, v interface{}) error {
+	return nil
+}
+
+func TestSubscriberTraceHeaders(t *testing.T) {
+	tr := &mdTracer{md: make(chan metadata.Metadata, 1)}
+	h := NewServer(func(o *server.Options) {
+		o.Tracer = tr
+		if o.Codecs == nil {
+			o.Codecs = make(map[string]codec.Codec)
+		}
+		o.Codecs["application/test"] = &testCodec{}
+	}).(*tcpServer)
+
+	got := make(chan metadata.Metadata, 1)
+	sb := h.NewSubscriber("topic", func(ctx context.Context, msg *string) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		got <- md
+		return nil
+	}).(*tcpSubscriber)
+
+	msg := &broker.Message{Header: map[string]string{
+		"Content-Type": "application/test",
+		"Traceparent":  "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	}}
+	if err := h.createSubHandler(sb, h.Options())(&testEvent{msg: msg}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, md := range map[string]metadata.Metadata{"tracer": <-tr.md, "handler": <-got} {
+		if v := md["Traceparent"]; v != msg.Header["Traceparent"] {
+			t.Fatalf("%s trace header %q, want %q", name, v, msg.Header["Traceparent"])
+		}
+		if _, ok := md["Content-Type"]; ok {
+			t.Fatalf("%s metadata contains Content-Type", name)
+		}
+	}
+}
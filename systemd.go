@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sdNotifyReady    = "READY=1"
+	sdNotifyStopping = "STOPPING=1"
+	sdNotifyWatchdog = "WATCHDOG=1"
+	sdNotifyMainPID  = "MAINPID="
+	// sdDefaultFDName is the name of sockets passed by systemd before v227
+	sdDefaultFDName = "unknown"
+	// sdSocketSuffix is the suffix of socket unit name used as name of
+	// sockets without FileDescriptorName
+	sdSocketSuffix = ".socket"
+)
+
+// systemdFiles returns sockets passed by systemd socket activation keyed
+// by LISTEN_FDNAMES, sockets sharing name keyed as name#0, name#1 and so on,
+// env cleared so sockets not passed further
+func systemdFiles() map[string]*os.File {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	names := make([]string, n)
+	count := make(map[string]int, n)
+	fdnames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := range names {
+		names[i] = sdDefaultFDName
+		if i < len(fdnames) && fdnames[i] != "" {
+			names[i] = fdnames[i]
+		}
+		count[names[i]]++
+	}
+	// sockets with the same name keyed by name and index among them
+	seen := make(map[string]int, n)
+	for i, name := range names {
+		if count[name] > 1 {
+			names[i] = name + "#" + strconv.Itoa(seen[name])
+			seen[name]++
+		}
+	}
+
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	return inheritFiles(names)
+}
+
+// sdNotify sends state to systemd if process started with NOTIFY_SOCKET
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	c, err := net.Dial("unixgram", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns interval of watchdog notifications, zero if
+// watchdog not enabled for the process
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifySystemd sends state to systemd and logs error
+func (h *tcpServer) notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		h.RLock()
+		config := h.opts
+		h.RUnlock()
+		config.Logger.Errorf(config.Context, "tcp: systemd notify %s error: %v", state, err)
+	}
+}
@@ -2,30 +2,48 @@
 package tcp // import "go.unistack.org/micro-server-tcp/v3"
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"net"
+	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.unistack.org/micro/v3/broker"
 	"go.unistack.org/micro/v3/codec"
 	"go.unistack.org/micro/v3/logger"
+	"go.unistack.org/micro/v3/metadata"
+	"go.unistack.org/micro/v3/meter"
 	"go.unistack.org/micro/v3/register"
 	"go.unistack.org/micro/v3/server"
+	"go.unistack.org/micro/v3/tracer"
 	"golang.org/x/net/netutil"
 )
 
 type tcpServer struct {
-	hd          server.Handler
-	rsvc        *register.Service
-	exit        chan chan error
-	subscribers map[*tcpSubscriber][]broker.Subscriber
-	opts        server.Options
+	connSeq      uint64
+	hd           server.Handler
+	rsvc         *register.Service
+	exit         chan chan error
+	subscribers  map[*tcpSubscriber][]broker.Subscriber
+	conns        map[string]*tcpConn
+	connsDone    chan struct{}
+	listeners    []*serverListener
+	inherited    map[string]*os.File
+	restartReady *os.File
+	limiter      *connLimiter
+	acl          atomic.Value
+	packetConn   net.PacketConn
+	loop         *eventLoop
+	opts         server.Options
 	sync.RWMutex
+	connMu     sync.Mutex
+	packetWg   sync.WaitGroup
 	registered bool
 	init       bool
+	handoff    bool
 }
 
 func (h *tcpServer) newCodec(ct string) (codec.Codec, error) {
@@ -51,6 +69,13 @@ func (h *tcpServer) Init(opts ...server.Option) error {
 	}
 	h.Unlock()
 
+	if err := h.updateConnLimits(); err != nil {
+		return err
+	}
+	if err := h.updateAccessList(); err != nil {
+		return err
+	}
+
 	if err := h.opts.Register.Init(); err != nil {
 		return err
 	}
@@ -83,8 +108,26 @@ func (h *tcpServer) Handle(handler server.Handler) error {
 func (h *tcpServer) NewHandler(handler interface{}, opts ...server.HandlerOption) server.Handler {
 	options := server.NewHandlerOptions(opts...)
 
+	var svc *rpcService
+	if !isConnHandler(handler) {
+		// not a raw connection handler, try to use it as rpc handler struct
+		if rs, err := newRPCService(handler); err == nil {
+			svc = rs
+		} else if h.opts.Logger.V(logger.ErrorLevel) {
+			h.opts.Logger.Errorf(h.opts.Context, "tcp: handler %T: %v", handler, err)
+		}
+	}
+
 	eps := make([]*register.Endpoint, 0, len(options.Metadata))
+	if svc != nil {
+		eps = append(eps, svc.endpoints(options.Metadata)...)
+	}
 	for name, metadata := range options.Metadata {
+		if svc != nil {
+			if _, ok := svc.method(name); ok {
+				continue
+			}
+		}
 		eps = append(eps, &register.Endpoint{
 			Name:     name,
 			Metadata: metadata,
@@ -92,9 +135,11 @@ func (h *tcpServer) NewHandler(handler interface{}, opts ...server.HandlerOption
 	}
 
 	th := &tcpHandler{
-		eps:  eps,
-		hd:   handler,
-		opts: options,
+		eps:        eps,
+		hd:         handler,
+		svc:        svc,
+		opts:       options,
+		maxMsgSize: DefaultMaxMsgSize,
 	}
 
 	if size, ok := h.opts.Context.Value(maxMsgSizeKey{}).(int); ok && size > 0 {
@@ -146,7 +191,7 @@ func (h *tcpServer) Register() error {
 		return nil
 	}
 
-	service, err := server.NewRegisterService(h)
+	service, err := h.newRegisterService()
 	if err != nil {
 		return err
 	}
@@ -155,6 +200,19 @@ func (h *tcpServer) Register() error {
 	service.Nodes[0].Metadata["transport"] = service.Nodes[0].Metadata["protocol"]
 	service.Endpoints = eps
 
+	// additional listeners advertised in node metadata
+	h.RLock()
+	for i, l := range h.listeners {
+		if i == 0 {
+			continue
+		}
+		service.Nodes[0].Metadata["listener."+l.name] = listenerAddress(l)
+		if l.co.tlsConfig != nil {
+			service.Nodes[0].Metadata["listener."+l.name+".secure"] = "true"
+		}
+	}
+	h.RUnlock()
+
 	h.Lock()
 
 	subscriberList := make([]*tcpSubscriber, 0, len(h.subscribers))
@@ -228,12 +286,55 @@ func (h *tcpServer) Register() error {
 	return nil
 }
 
+// newRegisterService creates register service, unix socket addresses has no
+// host and port so node created from server options
+func (h *tcpServer) newRegisterService() (*register.Service, error) {
+	h.RLock()
+	config := h.opts
+	pc := h.packetConn
+	h.RUnlock()
+
+	network, _ := parseAddress(config.Address)
+	nodeNetwork := network
+	if pc != nil {
+		nodeNetwork = pc.LocalAddr().Network()
+	}
+
+	if network != "unix" || len(config.Advertise) > 0 {
+		service, err := server.NewRegisterService(h)
+		if err != nil {
+			return nil, err
+		}
+		service.Nodes[0].Metadata["network"] = nodeNetwork
+		return service, nil
+	}
+
+	node := &register.Node{
+		ID:       config.Name + "-" + config.ID,
+		Address:  config.Address,
+		Metadata: metadata.Copy(config.Metadata),
+	}
+	if node.Metadata == nil {
+		node.Metadata = metadata.New(4)
+	}
+	node.Metadata["server"] = h.String()
+	node.Metadata["broker"] = config.Broker.String()
+	node.Metadata["register"] = config.Register.String()
+	node.Metadata["network"] = nodeNetwork
+
+	return &register.Service{
+		Name:    config.Name,
+		Version: config.Version,
+		Nodes:   []*register.Node{node},
+	}, nil
+}
+
 func (h *tcpServer) Deregister() error {
 	h.Lock()
 	config := h.opts
 	h.Unlock()
 
-	service, err := server.NewRegisterService(h)
+	service, err := h.newRegisterService()
 	if err != nil {
 		return err
 	}
@@ -302,55 +403,69 @@ func (h *tcpServer) Start() error {
 	hd := h.hd.Handler()
 	h.RUnlock()
 
-	var err error
-	var ts net.Listener
-
-	if l := h.getListener(); l != nil {
-		ts = l
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	// nolint: nestif
-	if ts == nil {
-		// check the tls config for secure connect
-		if config.TLSConfig != nil {
-			ts, err = tls.Listen("tcp", config.Address, config.TLSConfig)
-			// otherwise just plain tcp listener
-		} else {
-			ts, err = net.Listen("tcp", config.Address)
-		}
-		if err != nil {
-			return err
-		}
-
-		if config.Context != nil {
-			if c, ok := config.Context.Value(maxConnKey{}).(int); ok && c > 0 {
-				ts = netutil.LimitListener(ts, c)
-			}
-		}
-	}
+	h.loadInheritedFiles()
 
-	if config.Logger.V(logger.ErrorLevel) {
-		config.Logger.Infof(config.Context, "Listening on %s", ts.Addr().String())
+	var serve func(context.Context)
+	var err error
+	if ph, ok := hd.(PacketHandler); ok {
+		serve, err = h.listenPackets(ctx, config, ph)
+	} else if eh, ok := hd.(EventHandler); ok && h.getEventLoop() {
+		serve, err = h.listenEvents(ctx, config, eh)
+	} else {
+		serve, err = h.listenStreams(ctx, config, hd)
+	}
+	h.closeInheritedFiles()
+	if err != nil {
+		return err
 	}
 
-	h.Lock()
-	h.opts.Address = ts.Addr().String()
-	h.Unlock()
-
 	if err = config.Broker.Connect(config.Context); err != nil {
+		h.abortStart()
 		return err
 	}
 
 	// register
 	if err = h.Register(); err != nil {
+		h.abortStart()
+		_ = config.Broker.Disconnect(config.Context)
 		return err
 	}
 
-	handle, ok := hd.(Handler)
-	if !ok {
-		return fmt.Errorf("invalid handler %T", hd)
+	// listeners ready, parent process can stop accepting
+	h.notifyRestartReady()
+	h.notifySystemd(sdNotifyReady)
+
+	ctx = logger.NewContext(ctx, config.Logger)
+	ctx = tracer.NewContext(ctx, config.Tracer)
+	ctx = meter.NewContext(ctx, config.Meter)
+	ctx = server.NewContext(ctx, h)
+	ctx, cancel := context.WithCancel(ctx)
+
+	serve(ctx)
+
+	h.RLock()
+	idleTimeout := h.getDuration(idleTimeoutKey{})
+	certReload := h.getDuration(certReloadKey{})
+	certSource := h.getCertSource()
+	restartSignal := h.getRestartSignal()
+	h.RUnlock()
+	if idleTimeout > 0 {
+		go h.reapIdleConns(ctx, idleTimeout)
+	}
+	if r, ok := certSource.(CertReloader); ok {
+		if certReload <= 0 {
+			certReload = DefaultCertReloadInterval
+		}
+		go h.reloadCerts(ctx, r, certReload)
+	}
+	if restartSignal != nil {
+		go h.watchRestart(ctx, restartSignal)
 	}
-	go h.serve(ts, handle)
 
 	go func() {
 		t := new(time.Ticker)
@@ -361,6 +476,13 @@ func (h *tcpServer) Start() error {
 			t = time.NewTicker(config.RegisterInterval)
 		}
 
+		// systemd watchdog
+		wd := new(time.Ticker)
+		if interval := sdWatchdogInterval(); interval > 0 {
+			wd = time.NewTicker(interval)
+			defer wd.Stop()
+		}
+
 		// return error chan
 		var ch chan error
 
@@ -395,27 +517,255 @@ func (h *tcpServer) Start() error {
 						config.Logger.Errorf(config.Context, "Server %s-%s register error: %s", config.Name, config.ID, err)
 					}
 				}
+			case <-wd.C:
+				h.notifySystemd(sdNotifyWatchdog)
 				// wait for exit
 			case ch = <-h.exit:
 				break Loop
 			}
 		}
 
-		ch <- ts.Close()
+		// service not stopping if handed off to restarted process
+		h.RLock()
+		handoff := h.handoff
+		h.RUnlock()
+		if !handoff {
+			h.notifySystemd(sdNotifyStopping)
+		}
+
+		// stop accepting and notify handlers via connection context
+		cancel()
+		err := h.closeListeners()
 
 		// deregister
 		if cerr := h.Deregister(); cerr != nil {
 			config.Logger.Errorf(config.Context, "Register deregister error: %v", cerr)
 		}
 
+		h.drainConns(h.getGracefulTimeout())
+		h.drainPackets(h.getGracefulTimeout())
+
 		if cerr := config.Broker.Disconnect(config.Context); cerr != nil {
 			config.Logger.Errorf(config.Context, "Broker disconnect error: %v", cerr)
 		}
+
+		ch <- err
 	}()
 
 	return nil
 }
 
+// listenStreams opens stream listeners and returns func that starts
+// accepting connections on them
+func (h *tcpServer) listenStreams(ctx context.Context, config server.Options, hd interface{}) (func(context.Context), error) {
+	handle, err := h.newConnHandler(hd)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Context != nil {
+		if wrappers, ok := config.Context.Value(connWrappersKey{}).([]ConnWrapper); ok {
+			for i := len(wrappers); i > 0; i-- {
+				handle = wrappers[i-1](handle)
+			}
+		}
+	}
+
+	co, err := h.newConnOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.updateConnLimits(); err != nil {
+		return nil, err
+	}
+	if err = h.updateAccessList(); err != nil {
+		return nil, err
+	}
+
+	listeners, err := h.openListeners(ctx, config, co)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range listeners {
+		if config.Logger.V(logger.ErrorLevel) {
+			config.Logger.Infof(config.Context, "Listening on %s", l.Addr().String())
+		}
+	}
+
+	h.Lock()
+	h.opts.Address = listenerAddress(listeners[0])
+	h.listeners = listeners
+	h.Unlock()
+
+	h.registerConnGauge(config)
+
+	pool := h.newWorkerPool(config)
+
+	return func(ctx context.Context) {
+		if pool != nil {
+			pool.start(ctx)
+		}
+		for _, l := range listeners {
+			co := l.co
+			go h.serve(ctx, l, pool, func(c net.Conn) {
+				h.serveConn(ctx, c, co, handle)
+			})
+		}
+	}, nil
+}
+
+// listenPackets opens datagram socket and returns func that starts
+// reading datagrams from it
+func (h *tcpServer) listenPackets(ctx context.Context, config server.Options, hd PacketHandler) (func(context.Context), error) {
+	h.RLock()
+	pc := h.getPacketConn()
+	so := h.getSockOptions()
+	workers := h.getPacketWorkers()
+	_, maxMsgSize := h.getFraming()
+	h.RUnlock()
+
+	if err := h.updateAccessList(); err != nil {
+		return nil, err
+	}
+
+	if pc == nil {
+		var err error
+		if pc, err = h.inheritedPacketConn(config.Name); err != nil {
+			return nil, err
+		}
+	}
+	if pc == nil {
+		var err error
+		if pc, err = h.listenPacket(ctx, config.Address, so); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Logger.V(logger.ErrorLevel) {
+		config.Logger.Infof(config.Context, "Listening on %s %s", pc.LocalAddr().Network(), pc.LocalAddr().String())
+	}
+
+	h.Lock()
+	h.opts.Address = packetAddress(pc)
+	h.packetConn = pc
+	h.Unlock()
+
+	return func(ctx context.Context) {
+		h.packetWg.Add(1)
+		go func() {
+			defer h.packetWg.Done()
+			h.servePacket(ctx, pc, hd, workers, maxMsgSize)
+		}()
+	}, nil
+}
+
+// openListeners opens server Address or Listener and all listeners added
+// via AddListener, already opened listeners closed on error
+func (h *tcpServer) openListeners(ctx context.Context, config server.Options, co connOptions) ([]*serverListener, error) {
+	h.RLock()
+	so := h.getSockOptions()
+	tlsConfig := h.getTLSConfig()
+	var extra []ListenerConfig
+	if config.Context != nil {
+		extra, _ = config.Context.Value(listenersKey{}).([]ListenerConfig)
+	}
+	h.RUnlock()
+
+	primary := &serverListener{Listener: h.getListener(), name: config.Name, co: co}
+	if primary.Listener == nil {
+		ln, err := h.openListener(ctx, config.Name, config.Address, so)
+		if err != nil {
+			return nil, err
+		}
+		primary.raw = ln
+		// check the tls config for secure connect
+		primary.co.tlsConfig = tlsConfig
+		if config.Context != nil {
+			if c, ok := config.Context.Value(maxConnKey{}).(int); ok && c > 0 {
+				ln = netutil.LimitListener(ln, c)
+			}
+		}
+		primary.Listener = ln
+	}
+
+	listeners := []*serverListener{primary}
+	for _, lc := range extra {
+		l := &serverListener{Listener: lc.Listener, name: lc.Name, co: co}
+		if l.name == "" {
+			l.name = lc.Address
+		}
+		if l.Listener == nil {
+			ln, err := h.openListener(ctx, l.name, lc.Address, so)
+			if err != nil {
+				for _, l := range listeners {
+					_ = l.Close()
+				}
+				return nil, fmt.Errorf("listen on %s: %v", lc.Address, err)
+			}
+			l.raw = ln
+			l.Listener = ln
+		}
+		if lc.MaxConn > 0 {
+			l.Listener = netutil.LimitListener(l.Listener, lc.MaxConn)
+		}
+		l.co.tlsConfig = lc.TLSConfig
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// openListener returns listener inherited from parent process under name
+// or listens on address
+func (h *tcpServer) openListener(ctx context.Context, name string, address string, so sockOptions) (net.Listener, error) {
+	ln, err := h.inheritedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		return &sockoptListener{Listener: ln, opts: so}, nil
+	}
+	return h.listen(ctx, address, so)
+}
+
+// closeListeners closes all listeners and datagram socket opened on Start and returns first error
+func (h *tcpServer) closeListeners() error {
+	h.Lock()
+	listeners := h.listeners
+	pc := h.packetConn
+	h.listeners = nil
+	h.packetConn = nil
+	h.Unlock()
+
+	var err error
+	for _, l := range listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if pc != nil {
+		if cerr := pc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// abortStart closes listeners and not yet started event loop opened by
+// failed Start, so Start can be retried
+func (h *tcpServer) abortStart() {
+	_ = h.closeListeners()
+	h.Lock()
+	loop := h.loop
+	h.loop = nil
+	h.Unlock()
+	if loop != nil {
+		loop.close()
+	}
+}
+
 func (h *tcpServer) Stop() error {
 	ch := make(chan error)
 	h.exit <- ch
@@ -430,7 +780,96 @@ func (h *tcpServer) Name() string {
 	return h.opts.Name
 }
 
-func (h *tcpServer) serve(ln net.Listener, hd Handler) {
+func (h *tcpServer) getGracefulTimeout() time.Duration {
+	h.RLock()
+	defer h.RUnlock()
+
+	if h.opts.Context != nil {
+		if d, ok := h.opts.Context.Value(gracefulTimeoutKey{}).(time.Duration); ok && d >= 0 {
+			return d
+		}
+	}
+
+	return DefaultGracefulTimeout
+}
+
+func (h *tcpServer) getDuration(key interface{}) time.Duration {
+	if h.opts.Context == nil {
+		return 0
+	}
+	d, _ := h.opts.Context.Value(key).(time.Duration)
+	return d
+}
+
+func (h *tcpServer) getFraming() (Framing, int) {
+	maxMsgSize := DefaultMaxMsgSize
+	if th, ok := h.hd.(*tcpHandler); ok && th.maxMsgSize > 0 {
+		maxMsgSize = th.maxMsgSize
+	}
+
+	if h.opts.Context == nil {
+		return nil, maxMsgSize
+	}
+
+	f, ok := h.opts.Context.Value(framerKey{}).(Framing)
+	if !ok || f == nil {
+		return nil, maxMsgSize
+	}
+
+	return f, maxMsgSize
+}
+
+func isConnHandler(hd interface{}) bool {
+	switch hd.(type) {
+	case Handler, ContextHandler, PacketHandler, EventHandler:
+		return true
+	}
+	return false
+}
+
+// newConnHandler returns Handler for connections, if hd implements neither
+// Handler nor ContextHandler it treated as rpc handler struct and requests
+// dispatched via codecs
+func (h *tcpServer) newConnHandler(hd interface{}) (ContextHandler, error) {
+	switch handle := hd.(type) {
+	case ContextHandler:
+		return handle, nil
+	case Handler:
+		return &connHandler{hd: handle}, nil
+	case EventHandler:
+		return nil, fmt.Errorf("invalid handler %T: EventHandler requires EventLoop option", hd)
+	}
+
+	h.RLock()
+	framing, maxMsgSize := h.getFraming()
+	// hd is the handler of h.hd, its rpc service already parsed by NewHandler
+	var svc *rpcService
+	if th, ok := h.hd.(*tcpHandler); ok {
+		svc = th.svc
+	}
+	h.RUnlock()
+
+	if svc == nil {
+		var err error
+		if svc, err = newRPCService(hd); err != nil {
+			return nil, fmt.Errorf("invalid handler %T: %v", hd, err)
+		}
+	}
+	if framing == nil {
+		framing = DefaultRPCFraming
+	}
+
+	return &rpcHandler{
+		s:          h,
+		svc:        svc,
+		framing:    framing,
+		maxMsgSize: maxMsgSize,
+	}, nil
+}
+
+// serve accepts connections from ln and passes admitted ones to serveConn,
+// directly or via worker pool
+func (h *tcpServer) serve(ctx context.Context, ln *serverListener, pool *workerPool, serveConn func(net.Conn)) {
 	var tempDelay time.Duration // how long to sleep on accept failure
 	h.RLock()
 	config := h.opts
@@ -440,10 +879,16 @@ func (h *tcpServer) serve(ln net.Listener, hd Handler) {
 		// nolint: nestif
 		if err != nil {
 			select {
-			case <-h.exit:
+			case <-ctx.Done():
 				return
 			default:
 			}
+			// closed on restart while server still running
+			if ln.isClosed() {
+				return
+			}
+			config.Meter.Counter(metricAcceptErrors, metricLabels(config)...).Inc()
+			h.traceAcceptError(ctx, err)
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -469,7 +914,28 @@ func (h *tcpServer) serve(ln net.Listener, hd Handler) {
 			config.Logger.Errorf(config.Context, "tcp: accept err: %v", err)
 			return
 		}
-		go hd.Serve(c)
+		if ctx.Err() != nil {
+			_ = c.Close()
+			return
+		}
+
+		config.Meter.Counter(metricConnAccepted, metricLabels(config)...).Inc()
+
+		// with proxy protocol client address known only after header
+		// parsed, access list and limits applied by serveConn
+		if ln.co.proxy == nil {
+			var ok bool
+			if c, ok = h.admitConn(ctx, c); !ok {
+				continue
+			}
+		}
+		if pool == nil {
+			go serveConn(c)
+			continue
+		}
+		pool.submit(ctx, poolTask{c: c, run: func() {
+			serveConn(c)
+		}})
 	}
 }
 
@@ -477,6 +943,8 @@ func NewServer(opts ...server.Option) server.Server {
 	return &tcpServer{
 		opts:        server.NewOptions(opts...),
 		exit:        make(chan chan error),
+		conns:       make(map[string]*tcpConn),
+		limiter:     newConnLimiter(),
 		subscribers: make(map[*tcpSubscriber][]broker.Subscriber),
 	}
 }
@@ -0,0 +1,126 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"time"
+)
+
+// DefaultHandshakeTimeout specifies maximum duration of tls handshake
+var DefaultHandshakeTimeout = 10 * time.Second
+
+// PeerIdentity contains identity of the client from verified tls certificate
+type PeerIdentity struct {
+	// Certificate is the client leaf certificate
+	Certificate *x509.Certificate
+	// Subject is the certificate subject distinguished name
+	Subject string
+	// SPIFFEID is the spiffe:// uri SAN if present
+	SPIFFEID       string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// AuthorizeFunc called after tls handshake, returned error rejects connection,
+// peer is nil if client not provided certificate
+type AuthorizeFunc func(ctx context.Context, peer *PeerIdentity) error
+
+type peerKey struct{}
+
+// PeerFromContext returns verified client identity from handler context
+func PeerFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	p, ok := ctx.Value(peerKey{}).(*PeerIdentity)
+	return p, ok
+}
+
+func newPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	p := &PeerIdentity{
+		Certificate:    cert,
+		Subject:        cert.Subject.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			p.SPIFFEID = u.String()
+			break
+		}
+	}
+	return p
+}
+
+// getTLSConfig returns tls config for the listener, if AuthTLS option
+// specified client certificates required and verified, if TLSCertSource
+// specified certificates obtained from it
+func (h *tcpServer) getTLSConfig() *tls.Config {
+	cfg := h.opts.TLSConfig
+
+	if h.opts.Context == nil {
+		return cfg
+	}
+
+	if authTLS, ok := h.opts.Context.Value(tlsAuth{}).(*tls.Config); ok && authTLS != nil {
+		cfg = authTLS.Clone()
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		// use server certificates from TLSConfig if AuthTLS has only client ca
+		if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && h.opts.TLSConfig != nil {
+			cfg.Certificates = h.opts.TLSConfig.Certificates
+			cfg.GetCertificate = h.opts.TLSConfig.GetCertificate
+		}
+	}
+
+	if src := h.getCertSource(); src != nil {
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+		cfg.Certificates = nil
+		cfg.GetCertificate = src.GetCertificate
+	}
+
+	return cfg
+}
+
+func (h *tcpServer) getAuthorizeFunc() AuthorizeFunc {
+	if h.opts.Context == nil {
+		return nil
+	}
+	fn, _ := h.opts.Context.Value(authorizeKey{}).(AuthorizeFunc)
+	return fn
+}
+
+// handshake completes tls handshake and stores peer identity in connection
+// context
+func (h *tcpServer) handshake(tc *tcpConn) error {
+	tlsConn, ok := tc.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout)); err != nil {
+		return err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	if state := tlsConn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		peer := newPeerIdentity(state.PeerCertificates[0])
+		tc.ctx = context.WithValue(tc.ctx, peerKey{}, peer)
+	}
+
+	return nil
+}